@@ -0,0 +1,134 @@
+package log_v1
+
+import (
+	"strconv"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+)
+
+// errorDomain is the domain of the ErrorInfo details the typed errors carry,
+// whose reasons and metadata DecodeError turns back into the typed errors.
+const errorDomain = "log.v1"
+
+const (
+	reasonOffsetOutOfRange   = "OFFSET_OUT_OF_RANGE"
+	reasonOutOfOrderSequence = "OUT_OF_ORDER_SEQUENCE"
+	reasonSequenceTooOld     = "SEQUENCE_TOO_OLD"
+	reasonInvalidRecord      = "INVALID_RECORD"
+	reasonIncompatibleSchema = "INCOMPATIBLE_SCHEMA"
+	reasonQuotaExceeded      = "QUOTA_EXCEEDED"
+	reasonRecordTooLarge     = "RECORD_TOO_LARGE"
+	reasonInvalidRequest     = "INVALID_REQUEST"
+	reasonCorruptRecord      = "CORRUPT_RECORD"
+	reasonTopicNotFound      = "TOPIC_NOT_FOUND"
+	reasonNotLeader          = "NOT_LEADER"
+)
+
+func errorInfo(reason string, metadata map[string]string) *errdetails.ErrorInfo {
+	return &errdetails.ErrorInfo{
+		Reason:   reason,
+		Domain:   errorDomain,
+		Metadata: metadata,
+	}
+}
+
+func formatUint(v uint64) string {
+	return strconv.FormatUint(v, 10)
+}
+
+// DecodeError turns an error returned by a client call back into the typed
+// error the server returned, so clients can switch on it:
+//
+//	switch err := api.DecodeError(err).(type) {
+//	case api.ErrOffsetOutOfRange:
+//		// Resume from err.LowWatermark.
+//	case api.ErrQuotaExceeded:
+//		// Retry after err.RetryAfter.
+//	case api.ErrNotLeader:
+//		// Reconnect to err.Leader.
+//	}
+//
+// Errors that don't carry a typed error are returned as they are.
+func DecodeError(err error) error {
+	st, ok := status.FromError(err)
+	if !ok || st == nil {
+		return err
+	}
+	var retryAfter time.Duration
+	for _, d := range st.Details() {
+		if retry, ok := d.(*errdetails.RetryInfo); ok {
+			retryAfter = retry.RetryDelay.AsDuration()
+		}
+	}
+	for _, d := range st.Details() {
+		info, ok := d.(*errdetails.ErrorInfo)
+		if !ok || info.Domain != errorDomain {
+			continue
+		}
+		md := info.Metadata
+		switch info.Reason {
+		case reasonOffsetOutOfRange:
+			return ErrOffsetOutOfRange{
+				Offset:        parseUint(md["offset"]),
+				LowWatermark:  parseUint(md["low_watermark"]),
+				HighWatermark: parseUint(md["high_watermark"]),
+			}
+		case reasonOutOfOrderSequence:
+			return ErrOutOfOrderSequence{
+				ProducerID: parseUint(md["producer_id"]),
+				Sequence:   parseUint(md["sequence"]),
+				Expected:   parseUint(md["expected"]),
+			}
+		case reasonSequenceTooOld:
+			return ErrSequenceTooOld{
+				ProducerID: parseUint(md["producer_id"]),
+				Sequence:   parseUint(md["sequence"]),
+			}
+		case reasonInvalidRecord:
+			return ErrInvalidRecord{
+				Subject: md["subject"],
+				Version: uint32(parseUint(md["version"])),
+				Reason:  md["reason"],
+			}
+		case reasonIncompatibleSchema:
+			return ErrIncompatibleSchema{
+				Subject:       md["subject"],
+				Compatibility: Compatibility(Compatibility_value[md["compatibility"]]),
+				Reason:        md["reason"],
+			}
+		case reasonQuotaExceeded:
+			return ErrQuotaExceeded{
+				Subject:    md["subject"],
+				Action:     md["action"],
+				RetryAfter: retryAfter,
+			}
+		case reasonRecordTooLarge:
+			return ErrRecordTooLarge{
+				Size: parseUint(md["size"]),
+				Max:  parseUint(md["max"]),
+			}
+		case reasonCorruptRecord:
+			return ErrCorruptRecord{
+				Offset: parseUint(md["offset"]),
+				Reason: md["reason"],
+			}
+		case reasonInvalidRequest:
+			return ErrInvalidRequest{
+				Field:  md["field"],
+				Reason: md["reason"],
+			}
+		case reasonTopicNotFound:
+			return ErrTopicNotFound{Topic: md["topic"]}
+		case reasonNotLeader:
+			return ErrNotLeader{Leader: md["leader"]}
+		}
+	}
+	return err
+}
+
+func parseUint(s string) uint64 {
+	v, _ := strconv.ParseUint(s, 10, 64)
+	return v
+}
@@ -0,0 +1,41 @@
+package log_v1
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/status"
+)
+
+func TestDecodeError(t *testing.T) {
+	for scenario, err := range map[string]interface {
+		error
+		GRPCStatus() *status.Status
+	}{
+		"offset out of range":   ErrOffsetOutOfRange{Offset: 7, LowWatermark: 2, HighWatermark: 5},
+		"out of order sequence": ErrOutOfOrderSequence{ProducerID: 1, Sequence: 5, Expected: 4},
+		"sequence too old":      ErrSequenceTooOld{ProducerID: 1, Sequence: 2},
+		"invalid record":        ErrInvalidRecord{Subject: "orders", Version: 3, Reason: "missing id"},
+		"incompatible schema": ErrIncompatibleSchema{
+			Subject:       "orders",
+			Compatibility: Compatibility_COMPATIBILITY_BACKWARD,
+			Reason:        "field removed",
+		},
+		"quota exceeded":   ErrQuotaExceeded{Subject: "bob", Action: "produce", RetryAfter: 1500 * time.Millisecond},
+		"record too large": ErrRecordTooLarge{Size: 26, Max: 24},
+		"invalid request":  ErrInvalidRequest{Field: "topic", Reason: "is required"},
+		"corrupt record":   ErrCorruptRecord{Offset: 3, Reason: "unexpected EOF"},
+		"topic not found":  ErrTopicNotFound{Topic: "missing"},
+		"not leader":       ErrNotLeader{Leader: "10.0.0.1:8400"},
+		"unknown leader":   ErrNotLeader{},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			require.Equal(t, err, DecodeError(err.GRPCStatus().Err()))
+		})
+	}
+
+	err := errors.New("not a status")
+	require.Equal(t, err, DecodeError(err))
+}
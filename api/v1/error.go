@@ -2,29 +2,302 @@ package log_v1
 
 import (
 	"fmt"
+	"strings"
+	"time"
+
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/runtime/protoiface"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
+// ErrOffsetOutOfRange is returned when there's no record at an offset. The
+// log holds the records from LowWatermark up to, but not including,
+// HighWatermark, so an offset at or past HighWatermark can be read once more
+// records have been appended while one below LowWatermark is gone for good.
 type ErrOffsetOutOfRange struct {
-	Offset uint64
+	Offset        uint64
+	LowWatermark  uint64
+	HighWatermark uint64
 }
 
 func (e ErrOffsetOutOfRange) GRPCStatus() *status.Status {
-	st := status.New(404, fmt.Sprintf("offset out of range: %d", e.Offset))
+	st := status.New(codes.OutOfRange, fmt.Sprintf(
+		"offset out of range: %d, the log holds [%d, %d)",
+		e.Offset, e.LowWatermark, e.HighWatermark,
+	))
 	msg := fmt.Sprintf("The requested offset is outside the range of the log; %d",
 		e.Offset)
-	d := &errdetails.LocalizedMessage{
-		Locale: "en-US",
-		Message: msg,
+	return withDetails(st,
+		errorInfo(reasonOffsetOutOfRange, map[string]string{
+			"offset":         formatUint(e.Offset),
+			"low_watermark":  formatUint(e.LowWatermark),
+			"high_watermark": formatUint(e.HighWatermark),
+		}),
+		&errdetails.LocalizedMessage{
+			Locale:  "en-US",
+			Message: msg,
+		},
+	)
+}
+
+func (e ErrOffsetOutOfRange) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrOutOfOrderSequence is returned when an idempotent producer skips a
+// sequence number, usually because an earlier request is still in flight.
+// Retrying once the earlier request has been appended succeeds.
+type ErrOutOfOrderSequence struct {
+	ProducerID uint64
+	Sequence   uint64
+	Expected   uint64
+}
+
+func (e ErrOutOfOrderSequence) GRPCStatus() *status.Status {
+	st := status.New(codes.Aborted, fmt.Sprintf(
+		"out of order sequence for producer %d: got %d, want %d",
+		e.ProducerID, e.Sequence, e.Expected,
+	))
+	return withDetails(st, errorInfo(reasonOutOfOrderSequence, map[string]string{
+		"producer_id": formatUint(e.ProducerID),
+		"sequence":    formatUint(e.Sequence),
+		"expected":    formatUint(e.Expected),
+	}))
+}
+
+func (e ErrOutOfOrderSequence) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrSequenceTooOld is returned when a producer retries a sequence number
+// that is too far behind its latest one for the log to remember its offset.
+type ErrSequenceTooOld struct {
+	ProducerID uint64
+	Sequence   uint64
+}
+
+func (e ErrSequenceTooOld) GRPCStatus() *status.Status {
+	st := status.New(codes.FailedPrecondition, fmt.Sprintf(
+		"sequence %d for producer %d is too old", e.Sequence, e.ProducerID,
+	))
+	return withDetails(st, errorInfo(reasonSequenceTooOld, map[string]string{
+		"producer_id": formatUint(e.ProducerID),
+		"sequence":    formatUint(e.Sequence),
+	}))
+}
+
+func (e ErrSequenceTooOld) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrInvalidRecord is returned when a record's value doesn't match the schema
+// registered for its topic.
+type ErrInvalidRecord struct {
+	Subject string
+	Version uint32
+	Reason  string
+}
+
+func (e ErrInvalidRecord) GRPCStatus() *status.Status {
+	st := status.New(codes.InvalidArgument, fmt.Sprintf(
+		"record doesn't match version %d of schema %q: %s",
+		e.Version, e.Subject, e.Reason,
+	))
+	return withDetails(st, errorInfo(reasonInvalidRecord, map[string]string{
+		"subject": e.Subject,
+		"version": formatUint(uint64(e.Version)),
+		"reason":  e.Reason,
+	}))
+}
+
+func (e ErrInvalidRecord) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrIncompatibleSchema is returned when a new version of a subject's schema
+// fails the subject's compatibility check.
+type ErrIncompatibleSchema struct {
+	Subject       string
+	Compatibility Compatibility
+	Reason        string
+}
+
+func (e ErrIncompatibleSchema) GRPCStatus() *status.Status {
+	st := status.New(codes.FailedPrecondition, fmt.Sprintf(
+		"schema for %q is not %s compatible: %s",
+		e.Subject,
+		strings.ToLower(strings.TrimPrefix(e.Compatibility.String(), "COMPATIBILITY_")),
+		e.Reason,
+	))
+	return withDetails(st, errorInfo(reasonIncompatibleSchema, map[string]string{
+		"subject":       e.Subject,
+		"compatibility": e.Compatibility.String(),
+		"reason":        e.Reason,
+	}))
+}
+
+func (e ErrIncompatibleSchema) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrQuotaExceeded is returned when a subject has used up its quota for an
+// action. Retrying after RetryAfter succeeds unless other requests have used
+// the quota in the meantime.
+type ErrQuotaExceeded struct {
+	Subject    string
+	Action     string
+	RetryAfter time.Duration
+}
+
+func (e ErrQuotaExceeded) GRPCStatus() *status.Status {
+	st := status.New(codes.ResourceExhausted, fmt.Sprintf(
+		"%s quota exceeded for %q, retry after %s", e.Action, e.Subject, e.RetryAfter,
+	))
+	return withDetails(st,
+		errorInfo(reasonQuotaExceeded, map[string]string{
+			"subject": e.Subject,
+			"action":  e.Action,
+		}),
+		&errdetails.RetryInfo{
+			RetryDelay: durationpb.New(e.RetryAfter),
+		},
+	)
+}
+
+func (e ErrQuotaExceeded) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrRecordTooLarge is returned when a record is larger than the log accepts.
+type ErrRecordTooLarge struct {
+	Size uint64
+	Max  uint64
+}
+
+func (e ErrRecordTooLarge) GRPCStatus() *status.Status {
+	st := status.New(codes.InvalidArgument, fmt.Sprintf(
+		"record is %d bytes, the maximum is %d", e.Size, e.Max,
+	))
+	return withDetails(st,
+		errorInfo(reasonRecordTooLarge, map[string]string{
+			"size": formatUint(e.Size),
+			"max":  formatUint(e.Max),
+		}),
+		&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{{
+				Field:       "record",
+				Description: fmt.Sprintf("must be at most %d bytes", e.Max),
+			}},
+		},
+	)
+}
+
+func (e ErrRecordTooLarge) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrInvalidRequest is returned when a field of a request is missing or set
+// to a value that isn't allowed.
+type ErrInvalidRequest struct {
+	Field  string
+	Reason string
+}
+
+func (e ErrInvalidRequest) GRPCStatus() *status.Status {
+	st := status.New(codes.InvalidArgument, fmt.Sprintf(
+		"invalid %s: %s", e.Field, e.Reason,
+	))
+	return withDetails(st,
+		errorInfo(reasonInvalidRequest, map[string]string{
+			"field":  e.Field,
+			"reason": e.Reason,
+		}),
+		&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{{
+				Field:       e.Field,
+				Description: e.Reason,
+			}},
+		},
+	)
+}
+
+func (e ErrInvalidRequest) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrCorruptRecord is returned when the record stored at an offset can't be
+// decoded. The log's files have been damaged, so retrying won't help.
+type ErrCorruptRecord struct {
+	Offset uint64
+	Reason string
+}
+
+func (e ErrCorruptRecord) GRPCStatus() *status.Status {
+	st := status.New(codes.DataLoss, fmt.Sprintf(
+		"record at offset %d is corrupt: %s", e.Offset, e.Reason,
+	))
+	return withDetails(st, errorInfo(reasonCorruptRecord, map[string]string{
+		"offset": formatUint(e.Offset),
+		"reason": e.Reason,
+	}))
+}
+
+func (e ErrCorruptRecord) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrTopicNotFound is returned when a request names a topic the server
+// doesn't have.
+type ErrTopicNotFound struct {
+	Topic string
+}
+
+func (e ErrTopicNotFound) GRPCStatus() *status.Status {
+	st := status.New(codes.NotFound, fmt.Sprintf("topic %q not found", e.Topic))
+	return withDetails(st, errorInfo(reasonTopicNotFound, map[string]string{
+		"topic": e.Topic,
+	}), &errdetails.ResourceInfo{
+		ResourceType: "topic",
+		ResourceName: e.Topic,
+	})
+}
+
+func (e ErrTopicNotFound) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrNotLeader is returned when a request that only the leader can serve is
+// sent to another server. Leader is the address of the leader, if the server
+// knows it, and the request should be sent there.
+type ErrNotLeader struct {
+	Leader string
+}
+
+func (e ErrNotLeader) GRPCStatus() *status.Status {
+	msg := "server is not the leader"
+	if e.Leader != "" {
+		msg += ", the leader is " + e.Leader
 	}
-	std, err := st.WithDetails(d)
+	return withDetails(
+		status.New(codes.FailedPrecondition, msg),
+		errorInfo(reasonNotLeader, map[string]string{
+			"leader": e.Leader,
+		}),
+	)
+}
+
+func (e ErrNotLeader) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// withDetails returns st with details attached, or st itself if they can't
+// be.
+func withDetails(st *status.Status, details ...protoiface.MessageV1) *status.Status {
+	std, err := st.WithDetails(details...)
 	if err != nil {
 		return st
 	}
 	return std
 }
-
-func (e ErrOffsetOutOfRange) Error() string {
-	return e.GRPCStatus().Err().Error()
-}
\ No newline at end of file
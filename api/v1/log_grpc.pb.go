@@ -18,10 +18,25 @@ const _ = grpc.SupportPackageIsVersion7
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type LogClient interface {
+	// Consume returns the first record at or after the requested offset that
+	// the consumer can see, which for read-committed consumers skips control
+	// markers and the records of aborted transactions. Compare the returned
+	// record's offset to the requested one rather than assuming they're equal.
 	Consume(ctx context.Context, in *ConsumeRequest, opts ...grpc.CallOption) (*ConsumeResponse, error)
 	Produce(ctx context.Context, in *ProduceRequest, opts ...grpc.CallOption) (*ProduceResponse, error)
 	ConsumeStream(ctx context.Context, in *ConsumeRequest, opts ...grpc.CallOption) (Log_ConsumeStreamClient, error)
+	// ConsumeFlow is ConsumeStream with flow control: the server only sends as
+	// many records as the client has granted it credit for, so a slow client
+	// holds the server back instead of having records pile up for it.
+	ConsumeFlow(ctx context.Context, opts ...grpc.CallOption) (Log_ConsumeFlowClient, error)
+	ConsumeRange(ctx context.Context, in *ConsumeRangeRequest, opts ...grpc.CallOption) (*ConsumeRangeResponse, error)
 	ProduceStream(ctx context.Context, opts ...grpc.CallOption) (Log_ProduceStreamClient, error)
+	GetServers(ctx context.Context, in *GetServersRequest, opts ...grpc.CallOption) (*GetServersResponse, error)
+	BeginTransaction(ctx context.Context, in *BeginTransactionRequest, opts ...grpc.CallOption) (*BeginTransactionResponse, error)
+	CommitTransaction(ctx context.Context, in *CommitTransactionRequest, opts ...grpc.CallOption) (*CommitTransactionResponse, error)
+	AbortTransaction(ctx context.Context, in *AbortTransactionRequest, opts ...grpc.CallOption) (*AbortTransactionResponse, error)
+	RegisterSchema(ctx context.Context, in *RegisterSchemaRequest, opts ...grpc.CallOption) (*RegisterSchemaResponse, error)
+	GetSchema(ctx context.Context, in *GetSchemaRequest, opts ...grpc.CallOption) (*GetSchemaResponse, error)
 }
 
 type logClient struct {
@@ -82,8 +97,48 @@ func (x *logConsumeStreamClient) Recv() (*ConsumeResponse, error) {
 	return m, nil
 }
 
+func (c *logClient) ConsumeFlow(ctx context.Context, opts ...grpc.CallOption) (Log_ConsumeFlowClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Log_ServiceDesc.Streams[1], "/log.v1.Log/ConsumeFlow", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &logConsumeFlowClient{stream}
+	return x, nil
+}
+
+type Log_ConsumeFlowClient interface {
+	Send(*ConsumeFlowRequest) error
+	Recv() (*ConsumeResponse, error)
+	grpc.ClientStream
+}
+
+type logConsumeFlowClient struct {
+	grpc.ClientStream
+}
+
+func (x *logConsumeFlowClient) Send(m *ConsumeFlowRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *logConsumeFlowClient) Recv() (*ConsumeResponse, error) {
+	m := new(ConsumeResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *logClient) ConsumeRange(ctx context.Context, in *ConsumeRangeRequest, opts ...grpc.CallOption) (*ConsumeRangeResponse, error) {
+	out := new(ConsumeRangeResponse)
+	err := c.cc.Invoke(ctx, "/log.v1.Log/ConsumeRange", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *logClient) ProduceStream(ctx context.Context, opts ...grpc.CallOption) (Log_ProduceStreamClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Log_ServiceDesc.Streams[1], "/log.v1.Log/ProduceStream", opts...)
+	stream, err := c.cc.NewStream(ctx, &Log_ServiceDesc.Streams[2], "/log.v1.Log/ProduceStream", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -113,14 +168,83 @@ func (x *logProduceStreamClient) Recv() (*ProduceResponse, error) {
 	return m, nil
 }
 
+func (c *logClient) GetServers(ctx context.Context, in *GetServersRequest, opts ...grpc.CallOption) (*GetServersResponse, error) {
+	out := new(GetServersResponse)
+	err := c.cc.Invoke(ctx, "/log.v1.Log/GetServers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) BeginTransaction(ctx context.Context, in *BeginTransactionRequest, opts ...grpc.CallOption) (*BeginTransactionResponse, error) {
+	out := new(BeginTransactionResponse)
+	err := c.cc.Invoke(ctx, "/log.v1.Log/BeginTransaction", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) CommitTransaction(ctx context.Context, in *CommitTransactionRequest, opts ...grpc.CallOption) (*CommitTransactionResponse, error) {
+	out := new(CommitTransactionResponse)
+	err := c.cc.Invoke(ctx, "/log.v1.Log/CommitTransaction", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) AbortTransaction(ctx context.Context, in *AbortTransactionRequest, opts ...grpc.CallOption) (*AbortTransactionResponse, error) {
+	out := new(AbortTransactionResponse)
+	err := c.cc.Invoke(ctx, "/log.v1.Log/AbortTransaction", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) RegisterSchema(ctx context.Context, in *RegisterSchemaRequest, opts ...grpc.CallOption) (*RegisterSchemaResponse, error) {
+	out := new(RegisterSchemaResponse)
+	err := c.cc.Invoke(ctx, "/log.v1.Log/RegisterSchema", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) GetSchema(ctx context.Context, in *GetSchemaRequest, opts ...grpc.CallOption) (*GetSchemaResponse, error) {
+	out := new(GetSchemaResponse)
+	err := c.cc.Invoke(ctx, "/log.v1.Log/GetSchema", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // LogServer is the server API for Log service.
 // All implementations must embed UnimplementedLogServer
 // for forward compatibility
 type LogServer interface {
+	// Consume returns the first record at or after the requested offset that
+	// the consumer can see, which for read-committed consumers skips control
+	// markers and the records of aborted transactions. Compare the returned
+	// record's offset to the requested one rather than assuming they're equal.
 	Consume(context.Context, *ConsumeRequest) (*ConsumeResponse, error)
 	Produce(context.Context, *ProduceRequest) (*ProduceResponse, error)
 	ConsumeStream(*ConsumeRequest, Log_ConsumeStreamServer) error
+	// ConsumeFlow is ConsumeStream with flow control: the server only sends as
+	// many records as the client has granted it credit for, so a slow client
+	// holds the server back instead of having records pile up for it.
+	ConsumeFlow(Log_ConsumeFlowServer) error
+	ConsumeRange(context.Context, *ConsumeRangeRequest) (*ConsumeRangeResponse, error)
 	ProduceStream(Log_ProduceStreamServer) error
+	GetServers(context.Context, *GetServersRequest) (*GetServersResponse, error)
+	BeginTransaction(context.Context, *BeginTransactionRequest) (*BeginTransactionResponse, error)
+	CommitTransaction(context.Context, *CommitTransactionRequest) (*CommitTransactionResponse, error)
+	AbortTransaction(context.Context, *AbortTransactionRequest) (*AbortTransactionResponse, error)
+	RegisterSchema(context.Context, *RegisterSchemaRequest) (*RegisterSchemaResponse, error)
+	GetSchema(context.Context, *GetSchemaRequest) (*GetSchemaResponse, error)
 	mustEmbedUnimplementedLogServer()
 }
 
@@ -137,9 +261,33 @@ func (UnimplementedLogServer) Produce(context.Context, *ProduceRequest) (*Produc
 func (UnimplementedLogServer) ConsumeStream(*ConsumeRequest, Log_ConsumeStreamServer) error {
 	return status.Errorf(codes.Unimplemented, "method ConsumeStream not implemented")
 }
+func (UnimplementedLogServer) ConsumeFlow(Log_ConsumeFlowServer) error {
+	return status.Errorf(codes.Unimplemented, "method ConsumeFlow not implemented")
+}
+func (UnimplementedLogServer) ConsumeRange(context.Context, *ConsumeRangeRequest) (*ConsumeRangeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ConsumeRange not implemented")
+}
 func (UnimplementedLogServer) ProduceStream(Log_ProduceStreamServer) error {
 	return status.Errorf(codes.Unimplemented, "method ProduceStream not implemented")
 }
+func (UnimplementedLogServer) GetServers(context.Context, *GetServersRequest) (*GetServersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetServers not implemented")
+}
+func (UnimplementedLogServer) BeginTransaction(context.Context, *BeginTransactionRequest) (*BeginTransactionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BeginTransaction not implemented")
+}
+func (UnimplementedLogServer) CommitTransaction(context.Context, *CommitTransactionRequest) (*CommitTransactionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CommitTransaction not implemented")
+}
+func (UnimplementedLogServer) AbortTransaction(context.Context, *AbortTransactionRequest) (*AbortTransactionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AbortTransaction not implemented")
+}
+func (UnimplementedLogServer) RegisterSchema(context.Context, *RegisterSchemaRequest) (*RegisterSchemaResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterSchema not implemented")
+}
+func (UnimplementedLogServer) GetSchema(context.Context, *GetSchemaRequest) (*GetSchemaResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSchema not implemented")
+}
 func (UnimplementedLogServer) mustEmbedUnimplementedLogServer() {}
 
 // UnsafeLogServer may be embedded to opt out of forward compatibility for this service.
@@ -210,6 +358,50 @@ func (x *logConsumeStreamServer) Send(m *ConsumeResponse) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _Log_ConsumeFlow_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(LogServer).ConsumeFlow(&logConsumeFlowServer{stream})
+}
+
+type Log_ConsumeFlowServer interface {
+	Send(*ConsumeResponse) error
+	Recv() (*ConsumeFlowRequest, error)
+	grpc.ServerStream
+}
+
+type logConsumeFlowServer struct {
+	grpc.ServerStream
+}
+
+func (x *logConsumeFlowServer) Send(m *ConsumeResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *logConsumeFlowServer) Recv() (*ConsumeFlowRequest, error) {
+	m := new(ConsumeFlowRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Log_ConsumeRange_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConsumeRangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).ConsumeRange(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/log.v1.Log/ConsumeRange",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).ConsumeRange(ctx, req.(*ConsumeRangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Log_ProduceStream_Handler(srv interface{}, stream grpc.ServerStream) error {
 	return srv.(LogServer).ProduceStream(&logProduceStreamServer{stream})
 }
@@ -236,6 +428,114 @@ func (x *logProduceStreamServer) Recv() (*ProduceRequest, error) {
 	return m, nil
 }
 
+func _Log_GetServers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetServersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).GetServers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/log.v1.Log/GetServers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).GetServers(ctx, req.(*GetServersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_BeginTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BeginTransactionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).BeginTransaction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/log.v1.Log/BeginTransaction",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).BeginTransaction(ctx, req.(*BeginTransactionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_CommitTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CommitTransactionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).CommitTransaction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/log.v1.Log/CommitTransaction",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).CommitTransaction(ctx, req.(*CommitTransactionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_AbortTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AbortTransactionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).AbortTransaction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/log.v1.Log/AbortTransaction",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).AbortTransaction(ctx, req.(*AbortTransactionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_RegisterSchema_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterSchemaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).RegisterSchema(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/log.v1.Log/RegisterSchema",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).RegisterSchema(ctx, req.(*RegisterSchemaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_GetSchema_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSchemaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).GetSchema(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/log.v1.Log/GetSchema",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).GetSchema(ctx, req.(*GetSchemaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Log_ServiceDesc is the grpc.ServiceDesc for Log service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -251,6 +551,34 @@ var Log_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Produce",
 			Handler:    _Log_Produce_Handler,
 		},
+		{
+			MethodName: "ConsumeRange",
+			Handler:    _Log_ConsumeRange_Handler,
+		},
+		{
+			MethodName: "GetServers",
+			Handler:    _Log_GetServers_Handler,
+		},
+		{
+			MethodName: "BeginTransaction",
+			Handler:    _Log_BeginTransaction_Handler,
+		},
+		{
+			MethodName: "CommitTransaction",
+			Handler:    _Log_CommitTransaction_Handler,
+		},
+		{
+			MethodName: "AbortTransaction",
+			Handler:    _Log_AbortTransaction_Handler,
+		},
+		{
+			MethodName: "RegisterSchema",
+			Handler:    _Log_RegisterSchema_Handler,
+		},
+		{
+			MethodName: "GetSchema",
+			Handler:    _Log_GetSchema_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -258,6 +586,12 @@ var Log_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _Log_ConsumeStream_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "ConsumeFlow",
+			Handler:       _Log_ConsumeFlow_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 		{
 			StreamName:    "ProduceStream",
 			Handler:       _Log_ProduceStream_Handler,
@@ -0,0 +1,152 @@
+// Command proglogctl inspects and repairs the files of a log whose server is
+// stopped.
+//
+//	proglogctl -dir <log dir> segments
+//	proglogctl -dir <log dir> dump [-from offset] [-to offset]
+//	proglogctl -dir <log dir> verify [-repair]
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	api "github.com/srikantrao/proglog/api/v1"
+	commitlog "github.com/srikantrao/proglog/internal/log"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func main() {
+	dir := flag.String("dir", "", "log directory, e.g. <data-dir>/log")
+	flag.Usage = usage
+	flag.Parse()
+	if *dir == "" || flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch cmd, args := flag.Arg(0), flag.Args()[1:]; cmd {
+	case "segments":
+		err = segments(*dir)
+	case "dump":
+		err = dump(*dir, args)
+	case "verify":
+		err = verify(*dir, args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "proglogctl:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: proglogctl -dir <log dir> <command>
+
+commands:
+  segments               list the segments and their sizes
+  dump [-from] [-to]     print the records as JSON, one per line
+  verify [-repair]       check that the indexes match the stores, rebuilding
+                         the indexes that don't and truncating torn records
+                         with -repair`)
+}
+
+func segments(dir string) error {
+	infos, err := commitlog.Inspect(dir)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "BASE\tNEXT\tSTORE BYTES\tINDEX BYTES\tINDEX ENTRIES\tTORN BYTES")
+	for _, info := range infos {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\t%d\n",
+			info.BaseOffset, info.NextOffset, info.StoreBytes, info.IndexBytes, info.IndexEntries,
+			info.TornBytes)
+	}
+	return w.Flush()
+}
+
+func dump(dir string, args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	from := fs.Uint64("from", 0, "first offset to print")
+	to := fs.Uint64("to", 0, "offset to stop before, the end of the log when 0")
+	fs.Parse(args)
+
+	infos, err := commitlog.Inspect(dir)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	for _, info := range infos {
+		if info.NextOffset <= *from || (*to != 0 && info.BaseOffset >= *to) {
+			continue
+		}
+		err := commitlog.Dump(dir, info.BaseOffset, func(record *api.Record) error {
+			if record.Offset < *from || (*to != 0 && record.Offset >= *to) {
+				return nil
+			}
+			b, err := protojson.Marshal(record)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(out, "%s\n", b)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("segment %d: %w", info.BaseOffset, err)
+		}
+	}
+	return nil
+}
+
+func verify(dir string, args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	repair := fs.Bool("repair", false, "rebuild damaged or missing indexes from the stores")
+	fs.Parse(args)
+
+	infos, err := commitlog.Inspect(dir)
+	if err != nil {
+		return err
+	}
+	var damaged int
+	for _, info := range infos {
+		problems, err := commitlog.Verify(dir, info.BaseOffset)
+		if err != nil {
+			return fmt.Errorf("segment %d: %w", info.BaseOffset, err)
+		}
+		for _, p := range problems {
+			fmt.Printf("segment %d: %s\n", info.BaseOffset, p)
+		}
+		if len(problems) == 0 {
+			continue
+		}
+		if !*repair {
+			damaged++
+			continue
+		}
+		if err := commitlog.RepairIndex(dir, info.BaseOffset); err != nil {
+			return fmt.Errorf("segment %d: %w", info.BaseOffset, err)
+		}
+		if problems, err = commitlog.Verify(dir, info.BaseOffset); err != nil {
+			return fmt.Errorf("segment %d: %w", info.BaseOffset, err)
+		}
+		if len(problems) != 0 {
+			// The store itself is inconsistent, e.g. records with the
+			// wrong offsets, which a new index can't fix.
+			damaged++
+			continue
+		}
+		fmt.Printf("segment %d: rebuilt index\n", info.BaseOffset)
+	}
+	if damaged != 0 {
+		return fmt.Errorf("%d damaged segments", damaged)
+	}
+	fmt.Printf("%d segments ok\n", len(infos))
+	return nil
+}
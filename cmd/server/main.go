@@ -1,11 +1,260 @@
 package main
 
 import (
-	"github.com/srikantrao/proglog/internal/server"
+	"context"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	api "github.com/srikantrao/proglog/api/v1"
+	"github.com/srikantrao/proglog/internal/auth"
+	"github.com/srikantrao/proglog/internal/config"
+	commitlog "github.com/srikantrao/proglog/internal/log"
+	"github.com/srikantrao/proglog/internal/logging"
+	"github.com/srikantrao/proglog/internal/schema"
+	"github.com/srikantrao/proglog/internal/server"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 func main() {
-	srv := server.NewHTTPServer(":8080")
-	log.Fatal(srv.ListenAndServe())
-}
\ No newline at end of file
+	dataDir := flag.String("data-dir", filepath.Join(os.TempDir(), "proglog"), "directory to store log data")
+	rpcAddr := flag.String("rpc-addr", ":8400", "address for the gRPC server and JSON gateway")
+	advertiseAddr := flag.String("advertise-addr", "127.0.0.1:8400", "RPC address clients use to reach this server")
+	httpAddr := flag.String("http-addr", ":8080", "address for the HTTP server")
+	metricsAddr := flag.String("metrics-addr", "127.0.0.1:9090", "address to serve Prometheus metrics and log levels on, unauthenticated, disabled when empty")
+	auditSampleRate := flag.Float64("audit-allow-sample-rate", 1, "fraction of allowed authorization decisions to audit")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "address of the OTLP gRPC collector to export traces to, disabled when empty")
+	logLevel := zap.LevelFlag("log-level", zapcore.InfoLevel, "initial level of every subsystem's logs, changeable at runtime on the metrics address")
+	logDevelopment := flag.Bool("log-development", false, "write human-readable logs instead of JSON")
+	restoreFrom := flag.String("restore-from", "", "snapshot archive to bootstrap an empty log from")
+	segmentStoreBytes := flag.Uint64("segment-store-bytes", 64<<20, "size at which a log segment's store is rolled")
+	segmentIndexBytes := flag.Uint64("segment-index-bytes", 1<<20, "size at which a log segment's index is rolled")
+	maxRecordBytes := flag.Uint64("max-record-bytes", 1<<20, "size of the largest record clients may produce")
+	topicNames := flag.String("topics", "", "comma-separated names of the topics served besides the default log")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "how long to wait for in-flight requests when shutting down")
+	flag.Parse()
+
+	logs := logging.New(zapcore.Lock(os.Stderr), logging.Config{
+		Level:       *logLevel,
+		Development: *logDevelopment,
+	})
+
+	if *restoreFrom != "" {
+		if err := restore(filepath.Join(*dataDir, "log"), *restoreFrom); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// The server holds produced records to -max-record-bytes. The logs only
+	// limit records to what fits in their stores, so that the internal logs
+	// take large schemas and audit records.
+	logConfig := commitlog.Config{Logger: logs.Logger("log")}
+	logConfig.Segment.MaxStoreBytes = *segmentStoreBytes
+	logConfig.Segment.MaxIndexBytes = *segmentIndexBytes
+	clog, err := openLog(filepath.Join(*dataDir, "log"), logConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	auditLog, err := openLog(filepath.Join(*dataDir, "audit"), logConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	txnLog, err := openLog(filepath.Join(*dataDir, "transactions"), logConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	schemaLog, err := openLog(filepath.Join(*dataDir, "schemas"), logConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	topics, err := openTopics(filepath.Join(*dataDir, "topics"), *topicNames, logConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	schemas, err := schema.NewRegistry(schemaLog, schema.Config{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	auditor, err := auth.NewAuditor(auditLog, auth.AuditConfig{
+		AllowSampleRate: *auditSampleRate,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	authorizer := auth.New(config.ACLModelFile, config.ACLPolicyFile)
+	authorizer.Auditor = auditor
+	quotas, err := auth.LoadQuotas(config.QuotaFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.ServerCertFile,
+		KeyFile:  config.ServerKeyFile,
+		CAFile:   config.CAFile,
+		Server:   true,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	shutdown := make(chan struct{})
+	// Ready is left unset: a standalone server has no replication to catch
+	// up with, so it's ready whenever its logs take appends.
+	cfg := &server.Config{
+		CommitLog:      clog,
+		Topics:         make(map[string]server.CommitLog, len(topics)),
+		Authorizer:     authorizer,
+		Quotas:         quotas,
+		MaxRecordBytes: *maxRecordBytes,
+		GetServerer:    standalone{addr: *advertiseAddr},
+		TransactionLog: txnLog,
+		Schemas:        schemas,
+		Logger:         logs.Logger("server"),
+		Shutdown:       shutdown,
+	}
+	for name, l := range topics {
+		cfg.Topics[name] = l
+	}
+	var tp *sdktrace.TracerProvider
+	if *otlpEndpoint != "" {
+		if tp, err = server.NewTracerProvider(context.Background(), *otlpEndpoint); err != nil {
+			log.Fatal(err)
+		}
+		cfg.TracerProvider = tp
+	}
+
+	// gRPC and its JSON gateway share the RPC address.
+	gw, err := server.NewGatewayServer(*rpcAddr, cfg, tlsConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := server.NewHTTPServer(*httpAddr, cfg, tlsConfig)
+	servers := map[string]shutdowner{
+		gw.Addr:  gw,
+		srv.Addr: srv,
+	}
+	errc := make(chan error, 3)
+	go func() { errc <- gw.ListenAndServeTLS("", "") }()
+	go func() { errc <- srv.ListenAndServeTLS("", "") }()
+	// Metrics are served without TLS so that scrapers don't need a client
+	// certificate.
+	if *metricsAddr != "" {
+		metrics := server.NewMetricsServer(*metricsAddr, logs)
+		servers[metrics.Addr] = metrics
+		go func() { errc <- metrics.ListenAndServe() }()
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	logger := logs.Logger("main")
+	select {
+	case err := <-errc:
+		log.Fatal(err)
+	case sig := <-sigs:
+		logger.Info("shutting down", zap.Stringer("signal", sig))
+	}
+
+	// Streams end and tell their clients to reconnect elsewhere, then the
+	// servers wait for the requests in flight before the logs are closed,
+	// which flushes their buffered writes.
+	close(shutdown)
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for addr, s := range servers {
+		wg.Add(1)
+		go func(addr string, s shutdowner) {
+			defer wg.Done()
+			if err := s.Shutdown(ctx); err != nil {
+				logger.Error("stopping server", zap.String("addr", addr), zap.Error(err))
+			}
+		}(addr, s)
+	}
+	wg.Wait()
+	open := []*commitlog.Log{clog, txnLog, schemaLog, auditLog}
+	for _, l := range topics {
+		open = append(open, l)
+	}
+	for _, l := range open {
+		if err := l.Close(); err != nil {
+			logger.Error("closing log", zap.String("dir", l.Dir), zap.Error(err))
+		}
+	}
+	if tp != nil {
+		if err := tp.Shutdown(ctx); err != nil {
+			logger.Error("flushing traces", zap.Error(err))
+		}
+	}
+	logger.Info("shut down")
+	logger.Sync()
+}
+
+// shutdowner is implemented by the servers main runs, *http.Server and
+// *server.GatewayServer.
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// standalone reports this server as the only, and so leading, member of the
+// cluster.
+type standalone struct {
+	addr string
+}
+
+func (s standalone) GetServers() ([]*api.Server, error) {
+	return []*api.Server{{
+		Id:       s.addr,
+		RpcAddr:  s.addr,
+		IsLeader: true,
+	}}, nil
+}
+
+func openLog(dir string, c commitlog.Config) (*commitlog.Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return commitlog.NewLog(dir, c)
+}
+
+// openTopics opens a log under dir for each name in the comma-separated
+// list.
+func openTopics(dir, names string, c commitlog.Config) (map[string]*commitlog.Log, error) {
+	topics := make(map[string]*commitlog.Log)
+	if names == "" {
+		return topics, nil
+	}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return nil, fmt.Errorf("invalid topic name %q", name)
+		}
+		if _, ok := topics[name]; ok {
+			return nil, fmt.Errorf("topic %q listed twice", name)
+		}
+		l, err := openLog(filepath.Join(dir, name), c)
+		if err != nil {
+			return nil, err
+		}
+		topics[name] = l
+	}
+	return topics, nil
+}
+
+// restore writes the log in the snapshot archive to dir, which must not
+// already hold a log.
+func restore(dir, archive string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return commitlog.Restore(dir, f)
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	api "github.com/srikantrao/proglog/api/v1"
+	"github.com/srikantrao/proglog/internal/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+
+	// Allowed decisions are never sampled, denied ones always are.
+	a, err := NewAuditor(l, AuditConfig{AllowSampleRate: 0})
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, a.Record(ctx, "root", "*", "produce", true))
+	require.NoError(t, a.Record(ctx, "nobody", "*", "produce", false))
+
+	record, err := l.Read(0)
+	require.NoError(t, err)
+	var d Decision
+	require.NoError(t, json.Unmarshal(record.Value, &d))
+	require.Equal(t, "nobody", d.Subject)
+	require.False(t, d.Allowed)
+	require.Equal(t, "", d.PrevHash)
+
+	// A new auditor over the same log continues the chain.
+	a, err = NewAuditor(l, AuditConfig{AllowSampleRate: 1})
+	require.NoError(t, err)
+	require.NoError(t, a.Record(ctx, "root", "*", "consume", true))
+	require.NoError(t, a.Record(ctx, "root", "*", "produce", true))
+	require.NoError(t, VerifyAuditLog(l))
+
+	// A record that isn't chained to its predecessor is detected.
+	forged, err := json.Marshal(Decision{Subject: "root", Allowed: true})
+	require.NoError(t, err)
+	_, err = l.Append(&api.Record{Value: forged})
+	require.NoError(t, err)
+	require.Error(t, VerifyAuditLog(l))
+}
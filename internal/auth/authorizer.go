@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"fmt"
 	"github.com/casbin/casbin"
 	"google.golang.org/grpc/codes"
@@ -9,6 +10,8 @@ import (
 
 type Authorizer struct {
 	enforcer *casbin.Enforcer
+	// Auditor, when set, records every decision made by Enforce.
+	Auditor *Auditor
 }
 
 func New(model, policy string) *Authorizer {
@@ -18,8 +21,18 @@ func New(model, policy string) *Authorizer {
 	}
 }
 
-func (a *Authorizer) Enforce(subject, object, action string) error {
-	if !a.enforcer.Enforce(subject, object, action) {
+// Enforce checks whether subject may perform action on object. The decision
+// is written to the audit log along with the peer and RPC found in ctx. If the
+// decision cannot be audited the request is denied.
+func (a *Authorizer) Enforce(ctx context.Context, subject, object, action string) error {
+	allowed := a.enforcer.Enforce(subject, object, action)
+	if a.Auditor != nil {
+		if err := a.Auditor.Record(ctx, subject, object, action, allowed); err != nil {
+			st := status.New(codes.Unavailable, fmt.Sprintf("unable to audit authorization decision: %v", err))
+			return st.Err()
+		}
+	}
+	if !allowed {
 		msg := fmt.Sprintf("%s is not allowed to perform %s operation on %s", subject, action, object)
 		st := status.New(codes.PermissionDenied, msg)
 		return st.Err()
@@ -0,0 +1,237 @@
+package auth
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// anySubject is the subject of the quotas that apply to subjects without
+// quotas of their own.
+const anySubject = "*"
+
+// Quota limits how fast a subject may perform an action. Zero means no
+// limit.
+type Quota struct {
+	BytesPerSecond    float64
+	RequestsPerSecond float64
+}
+
+// Quotas enforces per-subject quotas with token buckets that hold a second's
+// worth of tokens, so subjects can burst up to their rate.
+type Quotas struct {
+	mu      sync.Mutex
+	policy  map[quotaKey]Quota
+	buckets map[quotaKey]*buckets
+}
+
+type quotaKey struct {
+	subject string
+	action  string
+}
+
+type buckets struct {
+	bytes    *rate.Limiter
+	requests *rate.Limiter
+}
+
+// LoadQuotas reads the quota policy file. Each line holds a subject, an
+// action and the bytes and requests per second the subject may use for it,
+// e.g.
+//
+//	# subject, action, bytes/s, requests/s
+//	*, produce, 1048576, 100
+//	root, produce, 0, 0
+//
+// The * subject sets the quotas of subjects that don't have their own, each
+// of which gets its own buckets.
+func LoadQuotas(file string) (*Quotas, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = 4
+	r.TrimLeadingSpace = true
+	policy := make(map[string]map[string]Quota)
+	for {
+		fields, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("quotas: %w", err)
+		}
+		var q Quota
+		if q.BytesPerSecond, err = parseRate(fields[2]); err != nil {
+			return nil, fmt.Errorf("quotas: %s %s: %w", fields[0], fields[1], err)
+		}
+		if q.RequestsPerSecond, err = parseRate(fields[3]); err != nil {
+			return nil, fmt.Errorf("quotas: %s %s: %w", fields[0], fields[1], err)
+		}
+		if policy[fields[0]] == nil {
+			policy[fields[0]] = make(map[string]Quota)
+		}
+		policy[fields[0]][fields[1]] = q
+	}
+	return NewQuotas(policy), nil
+}
+
+func parseRate(s string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 {
+		return 0, fmt.Errorf("negative rate %v", v)
+	}
+	return v, nil
+}
+
+// NewQuotas returns quotas that enforce policy, which maps subjects, or *,
+// to their quotas for each action.
+func NewQuotas(policy map[string]map[string]Quota) *Quotas {
+	q := &Quotas{
+		policy:  make(map[quotaKey]Quota),
+		buckets: make(map[quotaKey]*buckets),
+	}
+	for subject, actions := range policy {
+		for action, quota := range actions {
+			q.policy[quotaKey{subject: subject, action: action}] = quota
+		}
+	}
+	return q
+}
+
+// Admit takes a request of n bytes from the subject's quota for the action
+// if it has room for it. Otherwise it takes nothing and returns how long the
+// subject has to wait before the request would be admitted. A request larger
+// than the quota's bucket is admitted once the bucket is full, leaving the
+// subject in debt for the rest.
+func (q *Quotas) Admit(subject, action string, n int) time.Duration {
+	b := q.get(subject, action)
+	if b == nil {
+		return 0
+	}
+	now := time.Now()
+	var reserved []*rate.Reservation
+	for _, r := range []struct {
+		limiter *rate.Limiter
+		n       int
+	}{{b.requests, 1}, {b.bytes, n}} {
+		if r.limiter == nil {
+			continue
+		}
+		res := reserveN(r.limiter, now, r.n)
+		reserved = append(reserved, res...)
+		if d := res[0].DelayFrom(now); d > 0 {
+			for i := len(reserved) - 1; i >= 0; i-- {
+				reserved[i].CancelAt(now)
+			}
+			return d
+		}
+	}
+	return 0
+}
+
+// Charge takes n bytes from the subject's quota for the action even if it
+// doesn't have room for them, making later requests wait. It's for requests
+// whose size isn't known until they've been served, like reads.
+func (q *Quotas) Charge(subject, action string, n int) {
+	if b := q.get(subject, action); b != nil && b.bytes != nil {
+		reserveN(b.bytes, time.Now(), n)
+	}
+}
+
+// Wait blocks until the subject's quota for the action has room for a
+// request of n bytes, and takes it.
+func (q *Quotas) Wait(ctx context.Context, subject, action string, n int) error {
+	b := q.get(subject, action)
+	if b == nil {
+		return nil
+	}
+	if b.requests != nil {
+		if err := b.requests.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	if b.bytes == nil {
+		return nil
+	}
+	for {
+		k := chunk(b.bytes, n)
+		if err := b.bytes.WaitN(ctx, k); err != nil {
+			return err
+		}
+		if n -= k; n <= 0 {
+			return nil
+		}
+	}
+}
+
+// get returns the subject's buckets for the action, or nil if it isn't
+// limited.
+func (q *Quotas) get(subject, action string) *buckets {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	key := quotaKey{subject: subject, action: action}
+	if b, ok := q.buckets[key]; ok {
+		return b
+	}
+	quota, ok := q.policy[key]
+	if !ok {
+		quota, ok = q.policy[quotaKey{subject: anySubject, action: action}]
+	}
+	var b *buckets
+	if ok && (quota.BytesPerSecond != 0 || quota.RequestsPerSecond != 0) {
+		b = &buckets{
+			bytes:    newBucket(quota.BytesPerSecond),
+			requests: newBucket(quota.RequestsPerSecond),
+		}
+	}
+	q.buckets[key] = b
+	return b
+}
+
+func newBucket(perSecond float64) *rate.Limiter {
+	if perSecond == 0 {
+		return nil
+	}
+	burst := int(perSecond)
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), burst)
+}
+
+// reserveN takes n tokens from l. A limiter can reserve at most its burst
+// at once, so larger requests are split into several reservations, the first
+// of which is returned first; the later ones leave the bucket in debt.
+func reserveN(l *rate.Limiter, now time.Time, n int) []*rate.Reservation {
+	var reserved []*rate.Reservation
+	for {
+		k := chunk(l, n)
+		reserved = append(reserved, l.ReserveN(now, k))
+		if n -= k; n <= 0 {
+			return reserved
+		}
+	}
+}
+
+// chunk returns how much of n tokens l can take at once.
+func chunk(l *rate.Limiter, n int) int {
+	if n > l.Burst() {
+		return l.Burst()
+	}
+	return n
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotas(t *testing.T) {
+	f, err := ioutil.TempFile("", "quota-test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`# subject, action, bytes/s, requests/s
+*, produce, 100, 2
+*, consume, 10, 0
+root, produce, 0, 0
+`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	quotas, err := LoadQuotas(f.Name())
+	require.NoError(t, err)
+
+	// Subjects without their own quotas each get the default ones.
+	for _, subject := range []string{"alice", "bob"} {
+		require.Zero(t, quotas.Admit(subject, "produce", 10))
+		require.Zero(t, quotas.Admit(subject, "produce", 10))
+		require.NotZero(t, quotas.Admit(subject, "produce", 10))
+	}
+	// A request rejected for its size takes nothing from the request quota.
+	require.Zero(t, quotas.Admit("carol", "produce", 100))
+	require.NotZero(t, quotas.Admit("carol", "produce", 50))
+	require.Zero(t, quotas.Admit("carol", "produce", 0))
+
+	for i := 0; i < 10; i++ {
+		require.Zero(t, quotas.Admit("root", "produce", 1<<20))
+	}
+	require.Zero(t, quotas.Admit("alice", "admin", 1<<20))
+
+	// A request larger than the bucket is charged in full.
+	require.Zero(t, quotas.Admit("dave", "produce", 400))
+	d := quotas.Admit("dave", "produce", 0)
+	require.True(t, d > 2*time.Second, "got %s", d)
+
+	// Charged reads make the next ones wait until the debt is paid off.
+	require.Zero(t, quotas.Admit("alice", "consume", 0))
+	quotas.Charge("alice", "consume", 10)
+	require.Zero(t, quotas.Admit("alice", "consume", 0))
+	quotas.Charge("alice", "consume", 10)
+	require.NotZero(t, quotas.Admit("alice", "consume", 0))
+
+	start := time.Now()
+	require.NoError(t, quotas.Wait(context.Background(), "bob", "consume", 1))
+	require.True(t, time.Since(start) < 50*time.Millisecond)
+	require.NoError(t, quotas.Wait(context.Background(), "bob", "consume", 10))
+	require.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	start = time.Now()
+	require.NoError(t, quotas.Wait(context.Background(), "erin", "consume", 15))
+	require.True(t, time.Since(start) >= 400*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Error(t, quotas.Wait(ctx, "bob", "consume", 10))
+}
@@ -17,6 +17,7 @@ var (
 	ClientKeyFile        = ConfigFile("client-key.pem")
 	ACLModelFile         = ConfigFile("model.conf")
 	ACLPolicyFile        = ConfigFile("policy.csv")
+	QuotaFile            = ConfigFile("quota.csv")
 )
 
 // Get the absolute path to the current filename
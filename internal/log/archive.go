@@ -0,0 +1,222 @@
+package log
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+)
+
+// archiveVersion is the version of the format written by Snapshot. Restore
+// rejects archives with a version it doesn't know.
+const archiveVersion = 1
+
+const manifestName = "manifest.json"
+
+// manifest is the first entry of an archive. It is followed by a store and an
+// index entry for each segment, oldest first, and then the log's state.
+type manifest struct {
+	Version  int               `json:"version"`
+	Segments []archivedSegment `json:"segments"`
+}
+
+type archivedSegment struct {
+	BaseOffset uint64 `json:"base_offset"`
+	NextOffset uint64 `json:"next_offset"`
+	StoreBytes uint64 `json:"store_bytes"`
+	IndexBytes uint64 `json:"index_bytes"`
+}
+
+// archivedFile is a segment file as it was when the snapshot was taken. It's
+// opened while the log is locked so that a concurrent Truncate can't remove
+// it before it has been copied.
+type archivedFile struct {
+	name string
+	file *os.File
+	size uint64
+}
+
+// Snapshot writes a tar archive of the log to w that Restore can turn back
+// into a data directory. The archive holds the records up to the moment
+// Snapshot was called: appends only block while the segment files are
+// opened, not while they're copied.
+func (l *Log) Snapshot(w io.Writer) error {
+	m, files, state, err := l.archive()
+	for _, f := range files {
+		defer f.file.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := writeArchiveEntry(tw, manifestName, int64(len(b)), bytes.NewReader(b)); err != nil {
+		return err
+	}
+	for _, f := range files {
+		r := io.NewSectionReader(f.file, 0, int64(f.size))
+		if err := writeArchiveEntry(tw, f.name, int64(f.size), r); err != nil {
+			return err
+		}
+	}
+	if err := writeArchiveEntry(tw, snapshotFile, int64(len(state)), bytes.NewReader(state)); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// archive captures the segments and state to snapshot.
+func (l *Log) archive() (manifest, []archivedFile, []byte, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	m := manifest{Version: archiveVersion}
+	var files []archivedFile
+	for _, s := range l.segments {
+		if err := s.store.Flush(); err != nil {
+			return m, files, nil, err
+		}
+		m.Segments = append(m.Segments, archivedSegment{
+			BaseOffset: s.baseOffset,
+			NextOffset: s.nextOffset,
+			StoreBytes: s.store.size,
+			IndexBytes: s.index.size,
+		})
+		for _, f := range []struct {
+			ext  string
+			name string
+			size uint64
+		}{
+			{".store", s.store.Name(), s.store.size},
+			{".index", s.index.Name(), s.index.size},
+		} {
+			file, err := os.Open(f.name)
+			if err != nil {
+				return m, files, nil, err
+			}
+			files = append(files, archivedFile{
+				name: archiveName(s.baseOffset, f.ext),
+				file: file,
+				size: f.size,
+			})
+		}
+	}
+	state := *l.state
+	state.Offset = l.activeSegment.nextOffset
+	b, err := json.Marshal(&state)
+	return m, files, b, err
+}
+
+// Restore writes the log in the archive r, created by Snapshot, to dir, which
+// must not exist or be empty. Open the restored log with NewLog.
+func Restore(dir string, r io.Reader) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(entries) != 0 {
+		return fmt.Errorf("restore: directory %s is not empty", dir)
+	}
+
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return fmt.Errorf("restore: reading manifest: %w", err)
+	}
+	if hdr.Name != manifestName {
+		return fmt.Errorf("restore: archive starts with %q, not a manifest", hdr.Name)
+	}
+	var m manifest
+	if err := json.NewDecoder(tr).Decode(&m); err != nil {
+		return fmt.Errorf("restore: reading manifest: %w", err)
+	}
+	if m.Version != archiveVersion {
+		return fmt.Errorf("restore: unsupported archive version %d", m.Version)
+	}
+
+	for _, s := range m.Segments {
+		for _, f := range []struct {
+			ext  string
+			size uint64
+		}{
+			{".store", s.StoreBytes},
+			{".index", s.IndexBytes},
+		} {
+			name := archiveName(s.BaseOffset, f.ext)
+			if err := restoreFile(tr, name, segmentPath(dir, s.BaseOffset, f.ext), f.size); err != nil {
+				return err
+			}
+		}
+		if s.IndexBytes/entWidth != s.NextOffset-s.BaseOffset {
+			return fmt.Errorf("restore: segment %d has %d index entries for %d records",
+				s.BaseOffset, s.IndexBytes/entWidth, s.NextOffset-s.BaseOffset)
+		}
+	}
+	hdr, err = tr.Next()
+	if err != nil {
+		return fmt.Errorf("restore: reading state: %w", err)
+	}
+	if hdr.Name != snapshotFile {
+		return fmt.Errorf("restore: expected %q, got %q", snapshotFile, hdr.Name)
+	}
+	return restoreData(tr, path.Join(dir, snapshotFile), uint64(hdr.Size))
+}
+
+func restoreFile(tr *tar.Reader, name, dst string, size uint64) error {
+	hdr, err := tr.Next()
+	if err != nil {
+		return fmt.Errorf("restore: reading %s: %w", name, err)
+	}
+	if hdr.Name != name {
+		return fmt.Errorf("restore: expected %q, got %q", name, hdr.Name)
+	}
+	if uint64(hdr.Size) != size {
+		return fmt.Errorf("restore: %s is %d bytes, the manifest says %d", name, hdr.Size, size)
+	}
+	return restoreData(tr, dst, size)
+}
+
+func restoreData(r io.Reader, dst string, size uint64) error {
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, r, int64(size)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeArchiveEntry(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name: name,
+		Mode: 0644,
+		Size: size,
+	}); err != nil {
+		return err
+	}
+	_, err := io.CopyN(tw, r, size)
+	return err
+}
+
+// archiveName is the name of a segment file in an archive, which doesn't
+// carry over the on-disk naming quirk.
+func archiveName(baseOffset uint64, ext string) string {
+	return "segments/" + strconv.FormatUint(baseOffset, 10) + ext
+}
@@ -1,7 +1,23 @@
 package log
 
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
 type Config struct {
 	Segment
+	// MaxRecordBytes is the size of the largest record Append accepts.
+	// Defaults to the largest record that fits in a segment's store.
+	MaxRecordBytes uint64
+	// ProducerExpiry is how long the log remembers an idempotent producer
+	// that has stopped appending. A producer it has forgotten has to start
+	// again at sequence 1. Defaults to a week.
+	ProducerExpiry time.Duration
+	// Logger logs segment rolls, truncations and recovery at debug level.
+	// Defaults to a no-op logger.
+	Logger *zap.Logger
 }
 
 type Segment struct {
@@ -0,0 +1,250 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	api "github.com/srikantrao/proglog/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// The functions in this file look at a log's files without opening the log,
+// which would resize the index files, so that they can be used on the data of
+// a stopped server, including one that crashed.
+
+// SegmentInfo describes a segment's files.
+type SegmentInfo struct {
+	BaseOffset uint64 `json:"base_offset"`
+	// NextOffset is the offset after the last record in the store.
+	NextOffset   uint64 `json:"next_offset"`
+	StoreBytes   uint64 `json:"store_bytes"`
+	IndexBytes   uint64 `json:"index_bytes"`
+	IndexEntries uint64 `json:"index_entries"`
+	// TornBytes is the size of the record cut short at the end of the store,
+	// if a crash left one there.
+	TornBytes uint64 `json:"torn_bytes,omitempty"`
+}
+
+// Inspect returns the segments of the log in dir, oldest first.
+func Inspect(dir string) ([]SegmentInfo, error) {
+	baseOffsets, err := segmentBaseOffsets(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []SegmentInfo
+	for _, base := range baseOffsets {
+		info := SegmentInfo{BaseOffset: base, NextOffset: base}
+		end, err := readStore(dir, base, func(uint64, []byte) error {
+			info.NextOffset++
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if info.StoreBytes, err = fileSize(segmentPath(dir, base, ".store")); err != nil {
+			return nil, err
+		}
+		info.TornBytes = info.StoreBytes - end
+		if info.IndexBytes, err = fileSize(segmentPath(dir, base, ".index")); err != nil {
+			return nil, err
+		}
+		info.IndexEntries = info.IndexBytes / entWidth
+		segments = append(segments, info)
+	}
+	return segments, nil
+}
+
+// Dump calls fn with every record in the segment's store, in order. It reads
+// the store alone so that it works when the index is damaged.
+func Dump(dir string, baseOffset uint64, fn func(*api.Record) error) error {
+	_, err := readStore(dir, baseOffset, func(_ uint64, msg []byte) error {
+		record := &api.Record{}
+		if err := proto.Unmarshal(msg, record); err != nil {
+			return err
+		}
+		return fn(record)
+	})
+	return err
+}
+
+// Verify checks that the segment's index has an entry for every record in its
+// store, each pointing at the start of its record, and returns a description
+// of every problem it finds.
+func Verify(dir string, baseOffset uint64) ([]string, error) {
+	var positions []uint64
+	var offsets []uint64
+	end, err := readStore(dir, baseOffset, func(pos uint64, msg []byte) error {
+		record := &api.Record{}
+		if err := proto.Unmarshal(msg, record); err != nil {
+			return fmt.Errorf("record at position %d: %w", pos, err)
+		}
+		positions = append(positions, pos)
+		offsets = append(offsets, record.Offset)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	size, err := fileSize(segmentPath(dir, baseOffset, ".store"))
+	if err != nil {
+		return nil, err
+	}
+	var problems []string
+	if end < size {
+		problems = append(problems, fmt.Sprintf(
+			"store has a torn record of %d bytes at position %d", size-end, end))
+	}
+	b, err := ioutil.ReadFile(segmentPath(dir, baseOffset, ".index"))
+	if os.IsNotExist(err) {
+		return append(problems, "index is missing"), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if uint64(len(b))%entWidth != 0 {
+		problems = append(problems, fmt.Sprintf(
+			"index is %d bytes, not a multiple of the %d byte entry", len(b), entWidth))
+	}
+	entries := uint64(len(b)) / entWidth
+	if entries != uint64(len(positions)) {
+		problems = append(problems, fmt.Sprintf(
+			"index has %d entries for %d records", entries, len(positions)))
+	}
+	for i, off := range offsets {
+		if off != baseOffset+uint64(i) {
+			problems = append(problems, fmt.Sprintf(
+				"record %d in the store has offset %d, want %d", i, off, baseOffset+uint64(i)))
+		}
+	}
+	for i := uint64(0); i < entries && i < uint64(len(positions)); i++ {
+		ent := b[i*entWidth : (i+1)*entWidth]
+		rel := enc.Uint32(ent[:offWidth])
+		pos := enc.Uint64(ent[offWidth:])
+		if uint64(rel) != i {
+			problems = append(problems, fmt.Sprintf(
+				"index entry %d has relative offset %d", i, rel))
+		}
+		if pos != positions[i] {
+			problems = append(problems, fmt.Sprintf(
+				"index entry %d points at position %d, the record is at %d", i, pos, positions[i]))
+		}
+	}
+	return problems, nil
+}
+
+// RepairIndex rebuilds the segment's index from its store, first truncating
+// the store to its last whole record if a crash left a torn one at its end.
+func RepairIndex(dir string, baseOffset uint64) error {
+	var b []byte
+	var i uint32
+	end, err := readStore(dir, baseOffset, func(pos uint64, _ []byte) error {
+		var ent [entWidth]byte
+		enc.PutUint32(ent[:offWidth], i)
+		enc.PutUint64(ent[offWidth:], pos)
+		b = append(b, ent[:]...)
+		i++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	store := segmentPath(dir, baseOffset, ".store")
+	size, err := fileSize(store)
+	if err != nil {
+		return err
+	}
+	if end < size {
+		if err := os.Truncate(store, int64(end)); err != nil {
+			return err
+		}
+	}
+	name := segmentPath(dir, baseOffset, ".index")
+	if err := ioutil.WriteFile(name+".tmp", b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+// readStore calls fn with the position and contents of every record in the
+// segment's store and returns the position after the last whole one. A
+// record cut short at the end of the store, as a crash in the middle of a
+// write can leave behind, ends the store there; so does a length that runs
+// past the end of the file.
+func readStore(dir string, baseOffset uint64, fn func(pos uint64, msg []byte) error) (uint64, error) {
+	f, err := os.Open(segmentPath(dir, baseOffset, ".store"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := uint64(fi.Size())
+	var pos uint64
+	for size-pos >= lenWidth {
+		var n [lenWidth]byte
+		if _, err := io.ReadFull(f, n[:]); err != nil {
+			return pos, fmt.Errorf("record length at position %d: %w", pos, err)
+		}
+		length := enc.Uint64(n[:])
+		if length > size-pos-lenWidth {
+			break
+		}
+		msg := make([]byte, length)
+		if _, err := io.ReadFull(f, msg); err != nil {
+			return pos, fmt.Errorf("record at position %d: %w", pos, err)
+		}
+		if err := fn(pos, msg); err != nil {
+			return pos, err
+		}
+		pos += lenWidth + length
+	}
+	return pos, nil
+}
+
+// segmentBaseOffsets returns the base offsets of the segments in dir in
+// ascending order.
+func segmentBaseOffsets(dir string) ([]uint64, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[uint64]bool)
+	var baseOffsets []uint64
+	for _, file := range files {
+		// Segment files are named <baseOffset>.store/index.
+		offStr := strings.TrimSuffix(file.Name(), path.Ext(file.Name()))
+		off, err := strconv.ParseUint(offStr, 10, 0)
+		if err != nil {
+			// Not a segment file, e.g. the state snapshot.
+			continue
+		}
+		if !seen[off] {
+			seen[off] = true
+			baseOffsets = append(baseOffsets, off)
+		}
+	}
+	sort.Slice(baseOffsets, func(i, j int) bool {
+		return baseOffsets[i] < baseOffsets[j]
+	})
+	return baseOffsets, nil
+}
+
+func fileSize(name string) (uint64, error) {
+	fi, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return uint64(fi.Size()), nil
+}
@@ -0,0 +1,131 @@
+package log
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	api "github.com/srikantrao/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inspect-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 3
+	l, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err := l.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	infos, err := Inspect(dir)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(infos))
+	require.Equal(t, uint64(0), infos[0].BaseOffset)
+	require.Equal(t, uint64(3), infos[0].NextOffset)
+	require.Equal(t, uint64(3), infos[0].IndexEntries)
+	require.Equal(t, uint64(3), infos[1].BaseOffset)
+	require.Equal(t, uint64(5), infos[1].NextOffset)
+
+	var offsets []uint64
+	require.NoError(t, Dump(dir, 3, func(record *api.Record) error {
+		offsets = append(offsets, record.Offset)
+		return nil
+	}))
+	require.Equal(t, []uint64{3, 4}, offsets)
+
+	for _, info := range infos {
+		problems, err := Verify(dir, info.BaseOffset)
+		require.NoError(t, err)
+		require.Empty(t, problems)
+	}
+
+	// An index left at its full size by a crash, and a missing one.
+	require.NoError(t, os.Truncate(segmentPath(dir, 3, ".index"), int64(entWidth*3)))
+	problems, err := Verify(dir, 3)
+	require.NoError(t, err)
+	require.NotEmpty(t, problems)
+	require.NoError(t, os.Remove(segmentPath(dir, 0, ".index")))
+	problems, err = Verify(dir, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"index is missing"}, problems)
+
+	for _, base := range []uint64{0, 3} {
+		require.NoError(t, RepairIndex(dir, base))
+		problems, err := Verify(dir, base)
+		require.NoError(t, err)
+		require.Empty(t, problems)
+	}
+
+	// The repaired log opens and reads as before.
+	l, err = NewLog(dir, c)
+	require.NoError(t, err)
+	for i := uint64(0); i < 5; i++ {
+		record, err := l.Read(i)
+		require.NoError(t, err)
+		require.Equal(t, i, record.Offset)
+	}
+}
+
+func TestRepairTornRecord(t *testing.T) {
+	for scenario, torn := range map[string][]byte{
+		// A length and part of the record it's for.
+		"cut short record": {0, 0, 0, 0, 0, 0, 0, 100, 1, 2, 3},
+		// Garbage in place of a length.
+		"corrupt length":   {0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"cut short length": {0, 0, 0},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "inspect-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			c := Config{}
+			l, err := NewLog(dir, c)
+			require.NoError(t, err)
+			for i := 0; i < 3; i++ {
+				_, err := l.Append(&api.Record{Value: []byte("hello world")})
+				require.NoError(t, err)
+			}
+			require.NoError(t, l.Close())
+
+			f, err := os.OpenFile(segmentPath(dir, 0, ".store"), os.O_APPEND|os.O_WRONLY, 0)
+			require.NoError(t, err)
+			_, err = f.Write(torn)
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			infos, err := Inspect(dir)
+			require.NoError(t, err)
+			require.Equal(t, uint64(3), infos[0].NextOffset)
+			require.Equal(t, uint64(len(torn)), infos[0].TornBytes)
+			problems, err := Verify(dir, 0)
+			require.NoError(t, err)
+			require.Contains(t, problems, fmt.Sprintf(
+				"store has a torn record of %d bytes at position %d",
+				len(torn), infos[0].StoreBytes-uint64(len(torn))))
+
+			require.NoError(t, RepairIndex(dir, 0))
+			problems, err = Verify(dir, 0)
+			require.NoError(t, err)
+			require.Empty(t, problems)
+
+			l, err = NewLog(dir, c)
+			require.NoError(t, err)
+			defer l.Close()
+			off, err := l.Append(&api.Record{Value: []byte("hello world")})
+			require.NoError(t, err)
+			require.Equal(t, uint64(3), off)
+			record, err := l.Read(3)
+			require.NoError(t, err)
+			require.Equal(t, []byte("hello world"), record.Value)
+		})
+	}
+}
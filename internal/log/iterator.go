@@ -0,0 +1,133 @@
+package log
+
+import (
+	api "github.com/srikantrao/proglog/api/v1"
+)
+
+// Iterator steps through the records in a range of offsets. It works on the
+// segments the log had when it was created, so records appended afterwards
+// aren't included, and stops with an error if Truncate removes a segment it
+// still has to read.
+//
+//	it := l.Iterator(0, 0, false)
+//	for it.Next() {
+//		record := it.Record()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator struct {
+	log       *Log
+	segments  []*segment
+	from, to  uint64
+	reverse   bool
+	committed bool
+	current   int
+	record    *api.Record
+	err       error
+}
+
+// Iterator returns an Iterator over the records with offsets in [from, to),
+// oldest first or, if reverse is set, newest first. A to of 0, or past the
+// end of the log, iterates to the end of the log.
+func (l *Log) Iterator(from, to uint64, reverse bool) *Iterator {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.iterator(from, to, reverse, l.activeSegment.nextOffset)
+}
+
+// CommittedIterator is like Iterator but only returns the records that
+// ReadCommitted would: it skips control markers and aborted records and ends
+// at the last stable offset.
+func (l *Log) CommittedIterator(from, to uint64, reverse bool) *Iterator {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	it := l.iterator(from, to, reverse, l.state.Transactions.lastStableOffset(l.activeSegment.nextOffset))
+	it.committed = true
+	return it
+}
+
+func (l *Log) iterator(from, to uint64, reverse bool, end uint64) *Iterator {
+	if to == 0 || to > end {
+		to = end
+	}
+	if lowest := l.segments[0].baseOffset; from < lowest {
+		from = lowest
+	}
+	it := &Iterator{
+		log:      l,
+		segments: make([]*segment, len(l.segments)),
+		from:     from,
+		to:       to,
+		reverse:  reverse,
+	}
+	copy(it.segments, l.segments)
+	if reverse {
+		it.current = len(it.segments) - 1
+	}
+	return it
+}
+
+// Next advances to the next record, returning false at the end of the range
+// or on an error.
+func (it *Iterator) Next() bool {
+	for it.err == nil && it.from < it.to {
+		var off uint64
+		if it.reverse {
+			it.to--
+			off = it.to
+		} else {
+			off = it.from
+			it.from++
+		}
+		record, visible, err := it.read(off)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if visible {
+			it.record = record
+			return true
+		}
+	}
+	it.record = nil
+	return false
+}
+
+// Record returns the record Next advanced to.
+func (it *Iterator) Record() *api.Record {
+	return it.record
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+func (it *Iterator) read(off uint64) (*api.Record, bool, error) {
+	it.log.mu.RLock()
+	defer it.log.mu.RUnlock()
+	// Offsets are visited in order, so the segment is usually the current
+	// one or its neighbour.
+	for it.current >= 0 && it.current < len(it.segments) {
+		s := it.segments[it.current]
+		switch {
+		case off < s.baseOffset:
+			it.current--
+		case off >= s.nextOffset && it.current < len(it.segments)-1:
+			it.current++
+		default:
+			record, err := s.Read(off)
+			if err != nil {
+				return nil, false, err
+			}
+			return record, !it.committed || it.log.state.Transactions.visible(record), nil
+		}
+	}
+	high := it.log.activeSegment.nextOffset
+	if it.committed {
+		high = it.log.state.Transactions.lastStableOffset(high)
+	}
+	return nil, false, it.log.outOfRange(off, high)
+}
@@ -1,23 +1,36 @@
 package log
 
 import (
+	"fmt"
 	api "github.com/srikantrao/proglog/api/v1"
 	"io"
 	"io/ioutil"
 	"os"
-	"path"
-	"sort"
-	"strconv"
-	"strings"
 	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
 )
 
+// checkInterval is how long Check trusts its last attempt at writing to the
+// log's directory.
+const checkInterval = 10 * time.Second
+
 type Log struct {
 	mu            sync.RWMutex
 	Dir           string
 	Config        Config
 	activeSegment *segment
 	segments      []*segment
+	state         *snapshot
+	logger        *zap.Logger
+
+	checkMu sync.Mutex
+	// checked is when Check last tried writing to Dir, and checkErr what
+	// that returned.
+	checked  time.Time
+	checkErr error
 }
 
 type originReader struct {
@@ -38,63 +51,189 @@ func NewLog(dir string, c Config) (*Log, error) {
 	if c.Segment.MaxIndexBytes == 0 {
 		c.Segment.MaxIndexBytes = 1024
 	}
+	// A record can't be larger than a store less its length prefix.
+	var maxRecordBytes uint64
+	if c.Segment.MaxStoreBytes > lenWidth {
+		maxRecordBytes = c.Segment.MaxStoreBytes - lenWidth
+	}
+	if c.MaxRecordBytes == 0 || c.MaxRecordBytes > maxRecordBytes {
+		c.MaxRecordBytes = maxRecordBytes
+	}
+	if c.ProducerExpiry == 0 {
+		c.ProducerExpiry = 7 * 24 * time.Hour
+	}
+	if c.Logger == nil {
+		c.Logger = zap.NewNop()
+	}
 	l := &Log{
 		Dir:    dir,
 		Config: c,
+		logger: c.Logger.With(zap.String("dir", dir)),
 	}
 	return l, l.setup()
 }
 
 func (l *Log) setup() error {
-	files, err := ioutil.ReadDir(l.Dir)
+	baseOffsets, err := segmentBaseOffsets(l.Dir)
 	if err != nil {
 		return err
 	}
-	// Get the base offsets from the files
-	var baseOffsets []uint64
-	for _, file := range files {
-		// Get the Offset String - All File are in format <baseOffset>.store/index
-		offStr := strings.TrimSuffix(file.Name(), path.Ext(file.Name()))
-		off, _ := strconv.ParseUint(offStr, 10, 0)
-		baseOffsets = append(baseOffsets, off)
-	}
-	sort.Slice(baseOffsets, func(i, j int) bool {
-		return baseOffsets[i] < baseOffsets[j]
-	})
-	for i := 0; i < len(baseOffsets); i++ {
-		if err = l.newSegment(baseOffsets[i]); err != nil {
+	for _, off := range baseOffsets {
+		if err = l.newSegment(off); err != nil {
 			return err
 		}
-		// baseOffset contains dup for index and store so we skip
-		// the dup
-		i++
 	}
 	if l.segments == nil {
 		if err = l.newSegment(l.Config.InitialOffset); err != nil {
 			return err
 		}
 	}
+	return l.recoverState()
+}
+
+// recoverState loads the state snapshot and replays the records appended
+// after it was taken.
+func (l *Log) recoverState() error {
+	state, err := loadSnapshot(l.Dir)
+	if err != nil {
+		return err
+	}
+	off := state.Offset
+	if lowest := l.segments[0].baseOffset; off < lowest {
+		off = lowest
+	}
+	from := off
+	for ; off < l.activeSegment.nextOffset; off++ {
+		record, err := l.read(off)
+		if err != nil {
+			return err
+		}
+		state.apply(record)
+	}
+	l.state = state
+	l.logger.Debug("recovered log",
+		zap.Int("segments", len(l.segments)),
+		zap.Uint64("lowest_offset", l.segments[0].baseOffset),
+		zap.Uint64("next_offset", l.activeSegment.nextOffset),
+		zap.Uint64("replayed_from", from),
+	)
 	return nil
 }
 
 func (l *Log) Append(record *api.Record) (uint64, error) {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
+	if record == nil {
+		return 0, api.ErrInvalidRequest{Field: "record", Reason: "is required"}
+	}
+	start := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Measure the record as it will be stored, with its offset, which can
+	// add up to ten bytes.
+	offset := record.Offset
+	record.Offset = l.activeSegment.nextOffset
+	size := uint64(proto.Size(record))
+	record.Offset = offset
+	if size > l.Config.MaxRecordBytes {
+		return 0, api.ErrRecordTooLarge{Size: size, Max: l.Config.MaxRecordBytes}
+	}
+	if record.ProducerId != 0 {
+		off, duplicate, err := l.state.Producers.check(record)
+		if err != nil || duplicate {
+			return off, err
+		}
+	}
+	store, size := l.activeSegment.store, l.activeSegment.store.size
 	recordOffset, err := l.activeSegment.Append(record)
 	if err != nil {
 		return 0, err
 	}
+	bytesWritten.WithLabelValues(l.Dir).Add(float64(store.size - size))
+	l.state.apply(record)
 	// Check if the current segment is full. If yes, create a new one.
 	if l.activeSegment.IsMaxed() {
-		err = l.newSegment(recordOffset + 1)
+		l.state.Producers.expire(time.Now().Add(-l.Config.ProducerExpiry))
+		if err = l.state.save(l.Dir, recordOffset+1); err != nil {
+			return 0, err
+		}
+		if err = l.newSegment(recordOffset + 1); err == nil {
+			l.logger.Debug("rolled segment", zap.Uint64("base_offset", recordOffset+1))
+		}
 	}
+	l.updateSegmentMetrics()
+	appendDuration.WithLabelValues(l.Dir).Observe(time.Since(start).Seconds())
 	return recordOffset, err
 }
 
 func (l *Log) Read(offset uint64) (*api.Record, error) {
+	start := time.Now()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	record, err := l.read(offset)
+	readDuration.WithLabelValues(l.Dir).Observe(time.Since(start).Seconds())
+	return record, err
+}
+
+// ReadCommitted returns the first record at or after offset that is visible
+// to read-committed consumers: control markers and records of aborted
+// transactions are skipped, and records at or after the first offset of a
+// transaction that is still open are not available yet.
+func (l *Log) ReadCommitted(offset uint64) (*api.Record, error) {
+	start := time.Now()
 	l.mu.RLock()
 	defer l.mu.RUnlock()
+	defer func() {
+		readDuration.WithLabelValues(l.Dir).Observe(time.Since(start).Seconds())
+	}()
+	lso := l.state.Transactions.lastStableOffset(l.activeSegment.nextOffset)
+	for off := offset; off < lso; off++ {
+		record, err := l.read(off)
+		if err != nil {
+			return nil, err
+		}
+		if l.state.Transactions.visible(record) {
+			return record, nil
+		}
+	}
+	return nil, l.outOfRange(offset, lso)
+}
 
+// LastStableOffset returns the offset below which every transaction has been
+// committed or aborted.
+func (l *Log) LastStableOffset() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.state.Transactions.lastStableOffset(l.activeSegment.nextOffset)
+}
+
+// OffsetForTime returns the offset of the first record with a timestamp at or
+// after t, or the offset the next record appended gets if there's none.
+// Producers set the timestamps, so they aren't in order: the log skips the
+// segments whose latest timestamp is before t and reads the others.
+func (l *Log) OffsetForTime(t time.Time) (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, s := range l.segments {
+		latest, err := s.latestTimestamp()
+		if err != nil {
+			return 0, err
+		}
+		if latest.Before(t) {
+			continue
+		}
+		for off := s.baseOffset; off < s.nextOffset; off++ {
+			record, err := s.Read(off)
+			if err != nil {
+				return 0, err
+			}
+			if record.Timestamp != nil && !record.Timestamp.AsTime().Before(t) {
+				return off, nil
+			}
+		}
+	}
+	return l.activeSegment.nextOffset, nil
+}
+
+func (l *Log) read(offset uint64) (*api.Record, error) {
 	var s *segment
 
 	// Find the segment that contains this particular record of interest
@@ -106,22 +245,34 @@ func (l *Log) Read(offset uint64) (*api.Record, error) {
 	}
 	// If this offset is out of range of existing record offsets
 	if s == nil || s.nextOffset <= offset {
-		return nil, api.ErrOffsetOutOfRange{
-			Offset: offset,
-		}
+		return nil, l.outOfRange(offset, l.activeSegment.nextOffset)
 	}
 	return s.Read(offset)
 }
 
+// outOfRange returns the error for reading offset from the log when the
+// records up to high can be read. The caller must hold the lock.
+func (l *Log) outOfRange(offset, high uint64) error {
+	return api.ErrOffsetOutOfRange{
+		Offset:        offset,
+		LowWatermark:  l.segments[0].baseOffset,
+		HighWatermark: high,
+	}
+}
+
 // Close iterates over the segments in the log and closes them.
 func (l *Log) Close() error {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
+	if err := l.state.save(l.Dir, l.activeSegment.nextOffset); err != nil {
+		return err
+	}
 	for _, segment := range l.segments {
 		if err := segment.Close(); err != nil {
 			return err
 		}
 	}
+	l.deleteMetrics()
 	return nil
 }
 
@@ -147,6 +298,7 @@ func (l *Log) newSegment(off uint64) error {
 	}
 	l.segments = append(l.segments, s)
 	l.activeSegment = s
+	l.updateSegmentMetrics()
 	return nil
 }
 
@@ -171,24 +323,121 @@ func (l *Log) HighestOffset() (uint64, error) {
 // Truncate removes all the segments from the log whose highest offset is lower than the lowest value.
 // This function is called periodically to free up disk space.
 func (l *Log) Truncate(lowest uint64) error {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	var segments []*segment
+	removed := 0
 	for _, seg := range l.segments {
-		// delete segments whose next offset is lower than lowest
-		if seg.nextOffset < lowest {
+		// delete segments whose records are all below lowest, but
+		// never the active segment, which appends still need.
+		if seg.nextOffset <= lowest && seg != l.activeSegment {
 			if err := seg.Remove(); err != nil {
 				return err
 			}
+			removed++
 			continue
 		}
 		// Add segment back to log if it is not going to be deleted.
 		segments = append(segments, seg)
 	}
 	l.segments = segments
+	l.state.Transactions.truncate(lowest)
+	l.logger.Debug("truncated log",
+		zap.Uint64("lowest", lowest),
+		zap.Int("removed_segments", removed),
+		zap.Uint64("lowest_offset", l.segments[0].baseOffset),
+	)
+	truncations.WithLabelValues(l.Dir).Inc()
+	truncatedSegments.WithLabelValues(l.Dir).Add(float64(removed))
+	l.updateSegmentMetrics()
 	return nil
 }
 
+// Roll closes the active segment to appends and starts a new one. It does
+// nothing if the active segment is empty.
+func (l *Log) Roll() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	next := l.activeSegment.nextOffset
+	if next == l.activeSegment.baseOffset {
+		return nil
+	}
+	l.state.Producers.expire(time.Now().Add(-l.Config.ProducerExpiry))
+	if err := l.state.save(l.Dir, next); err != nil {
+		return err
+	}
+	if err := l.newSegment(next); err != nil {
+		return err
+	}
+	l.logger.Debug("rolled segment", zap.Uint64("base_offset", next), zap.Bool("requested", true))
+	return nil
+}
+
+// Segments describes the log's segments, oldest first. The last one is the
+// active segment.
+func (l *Log) Segments() []SegmentInfo {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	infos := make([]SegmentInfo, len(l.segments))
+	for i, s := range l.segments {
+		infos[i] = SegmentInfo{
+			BaseOffset:   s.baseOffset,
+			NextOffset:   s.nextOffset,
+			StoreBytes:   s.store.size,
+			IndexBytes:   s.index.size,
+			IndexEntries: s.index.size / entWidth,
+		}
+	}
+	return infos
+}
+
+// DiskUsage returns the number of bytes the log's files take up, which
+// includes the space reserved for the active segment's index.
+func (l *Log) DiskUsage() (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	files, err := ioutil.ReadDir(l.Dir)
+	if err != nil {
+		return 0, err
+	}
+	var total uint64
+	for _, f := range files {
+		if !f.IsDir() {
+			total += uint64(f.Size())
+		}
+	}
+	return total, nil
+}
+
+// Check returns an error if the log can't take appends: its active segment
+// has been closed or its directory isn't writable. Writing to the directory
+// is only tried again once checkInterval has passed since the last try.
+func (l *Log) Check() error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if _, err := l.activeSegment.store.Stat(); err != nil {
+		return fmt.Errorf("active segment %d: %w", l.activeSegment.baseOffset, err)
+	}
+	l.checkMu.Lock()
+	defer l.checkMu.Unlock()
+	if time.Since(l.checked) < checkInterval {
+		return l.checkErr
+	}
+	l.checked = time.Now()
+	l.checkErr = checkWritable(l.Dir)
+	return l.checkErr
+}
+
+// checkWritable returns an error if a file can't be created in dir.
+func checkWritable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".check-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
 func (l *Log) Reader() io.Reader {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
@@ -1,12 +1,21 @@
 package log
 
 import (
+	"bytes"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	api "github.com/srikantrao/proglog/api/v1"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 	"google.golang.org/protobuf/proto"
 	"io/ioutil"
 	"os"
+	"path"
 	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func TestLog(t *testing.T) {
@@ -15,7 +24,14 @@ func TestLog(t *testing.T) {
 		"offset out of bounds error":        testOutOfRangeErr,
 		"init with existing segments":       testInitSegments,
 		"testing the truncate code":         testTruncate,
+		"truncate at a segment boundary":    testTruncateBoundary,
 		"testing the reader code":           testReader,
+		"idempotent producers are deduped":  testIdempotentProducer,
+		"iterate across segments":           testIterator,
+		"snapshot and restore":              testSnapshotRestore,
+		"appends are measured":              testMetrics,
+		"invalid records are rejected":      testAppendValidation,
+		"offsets are found by timestamp":    testOffsetForTime,
 	} {
 		t.Run(scenario, func(t *testing.T) {
 			dir, err := ioutil.TempDir("", "store-test")
@@ -52,6 +68,16 @@ func testOutOfRangeErr(t *testing.T, log *Log) {
 	require.Nil(t, read)
 	apiErr := err.(api.ErrOffsetOutOfRange)
 	require.Equal(t, uint64(1), apiErr.Offset)
+	require.Equal(t, uint64(0), apiErr.HighWatermark)
+
+	_, err = log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	_, err = log.Read(1)
+	require.Equal(t, api.ErrOffsetOutOfRange{
+		Offset:        1,
+		LowWatermark:  0,
+		HighWatermark: 1,
+	}, err)
 }
 
 func testInitSegments(t *testing.T, l *Log) {
@@ -114,6 +140,31 @@ func testTruncate(t *testing.T, l *Log) {
 
 	_, err = l.Read(0)
 	require.Error(t, err)
+
+	// Truncating past the end of the log keeps the active segment.
+	require.NoError(t, l.Truncate(100))
+	off, err := l.Append(testRecord)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+}
+
+func testTruncateBoundary(t *testing.T, l *Log) {
+	_, err := l.Append(&api.Record{Value: []byte("first")})
+	require.NoError(t, err)
+	require.NoError(t, l.Roll())
+	_, err = l.Append(&api.Record{Value: []byte("second")})
+	require.NoError(t, err)
+
+	// The first segment holds offset 0, so it stays until lowest passes it.
+	require.NoError(t, l.Truncate(0))
+	lowest, err := l.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), lowest)
+
+	require.NoError(t, l.Truncate(1))
+	lowest, err = l.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), lowest)
 }
 
 func testReader(t *testing.T, log *Log) {
@@ -133,3 +184,248 @@ func testReader(t *testing.T, log *Log) {
 	require.NoError(t, err)
 	require.Equal(t, testRecord.Value, read.Value)
 }
+
+func testIdempotentProducer(t *testing.T, l *Log) {
+	produce := func(l *Log, sequence uint64) (uint64, error) {
+		return l.Append(&api.Record{
+			Value:      []byte("hello world"),
+			ProducerId: 42,
+			Sequence:   sequence,
+		})
+	}
+	for i := uint64(1); i <= 3; i++ {
+		off, err := produce(l, i)
+		require.NoError(t, err)
+		require.Equal(t, i-1, off)
+	}
+
+	// Retries return the original offset without appending.
+	off, err := produce(l, 2)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+	highest, err := l.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), highest)
+
+	_, err = produce(l, 5)
+	require.Equal(t, api.ErrOutOfOrderSequence{ProducerID: 42, Sequence: 5, Expected: 4}, err)
+
+	// A new producer starts at 1: a later batch arriving first waits for
+	// the earlier ones rather than making them too old.
+	_, err = l.Append(&api.Record{ProducerId: 43, Sequence: 2})
+	require.Equal(t, api.ErrOutOfOrderSequence{ProducerID: 43, Sequence: 2, Expected: 1}, err)
+
+	// The state survives a restart, with and without the snapshot.
+	require.NoError(t, l.Close())
+	for _, removeSnapshot := range []bool{false, true} {
+		if removeSnapshot {
+			require.NoError(t, os.Remove(path.Join(l.Dir, snapshotFile)))
+		}
+		l, err = NewLog(l.Dir, l.Config)
+		require.NoError(t, err)
+		off, err = produce(l, 3)
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), off)
+		require.NoError(t, l.Close())
+	}
+
+	// Producers that have been idle for too long are forgotten when the log
+	// rolls.
+	l, err = NewLog(l.Dir, l.Config)
+	require.NoError(t, err)
+	defer l.Close()
+	_, err = l.Append(&api.Record{
+		ProducerId: 44,
+		Sequence:   1,
+		Timestamp:  timestamppb.New(time.Now().Add(-2 * l.Config.ProducerExpiry)),
+	})
+	require.NoError(t, err)
+	_, err = produce(l, 4)
+	require.NoError(t, err)
+	require.NoError(t, l.Roll())
+	require.NotContains(t, l.state.Producers, uint64(44))
+	require.Contains(t, l.state.Producers, uint64(42))
+}
+
+func testIterator(t *testing.T, l *Log) {
+	for i := 0; i < 5; i++ {
+		_, err := l.Append(&api.Record{Value: []byte{byte(i)}})
+		require.NoError(t, err)
+	}
+	require.True(t, len(l.segments) > 1)
+
+	offsets := func(it *Iterator) []uint64 {
+		var offs []uint64
+		for it.Next() {
+			require.Equal(t, []byte{byte(it.Record().Offset)}, it.Record().Value)
+			offs = append(offs, it.Record().Offset)
+		}
+		require.NoError(t, it.Err())
+		return offs
+	}
+	require.Equal(t, []uint64{0, 1, 2, 3, 4}, offsets(l.Iterator(0, 0, false)))
+	require.Equal(t, []uint64{1, 2}, offsets(l.Iterator(1, 3, false)))
+	require.Equal(t, []uint64{4, 3, 2, 1, 0}, offsets(l.Iterator(0, 0, true)))
+	require.Equal(t, []uint64{3, 2}, offsets(l.Iterator(2, 4, true)))
+	require.Empty(t, offsets(l.Iterator(5, 0, false)))
+
+	// Records appended after the iterator was created aren't included.
+	it := l.Iterator(3, 0, false)
+	_, err := l.Append(&api.Record{Value: []byte{5}})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{3, 4}, offsets(it))
+
+	// The committed iterator stops at open transactions and skips aborted
+	// records and markers.
+	for _, record := range []*api.Record{
+		{Value: []byte{6}, TransactionId: 1},
+		{TransactionId: 1, Control: api.Control_CONTROL_ABORT},
+		{Value: []byte{8}},
+		{Value: []byte{9}, TransactionId: 2},
+	} {
+		_, err := l.Append(record)
+		require.NoError(t, err)
+	}
+	require.Equal(t, []uint64{8, 5, 4}, offsets(l.CommittedIterator(4, 0, true)))
+	require.Equal(t, []uint64{4, 5, 8}, offsets(l.CommittedIterator(4, 0, false)))
+}
+
+func testSnapshotRestore(t *testing.T, l *Log) {
+	for i := uint64(1); i <= 5; i++ {
+		_, err := l.Append(&api.Record{
+			Value:      []byte("hello world"),
+			ProducerId: 42,
+			Sequence:   i,
+		})
+		require.NoError(t, err)
+	}
+	var buf bytes.Buffer
+	require.NoError(t, l.Snapshot(&buf))
+	segments := len(l.segments)
+	// Appends after the snapshot aren't in it.
+	_, err := l.Append(&api.Record{Value: []byte("after snapshot")})
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "restore-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	archive := buf.Bytes()
+	require.NoError(t, Restore(dir, bytes.NewReader(archive)))
+	require.Error(t, Restore(dir, bytes.NewReader(archive)))
+
+	restored, err := NewLog(dir, l.Config)
+	require.NoError(t, err)
+	require.Equal(t, segments, len(restored.segments))
+	off, err := restored.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), off)
+	for i := uint64(0); i <= off; i++ {
+		record, err := restored.Read(i)
+		require.NoError(t, err)
+		require.Equal(t, []byte("hello world"), record.Value)
+	}
+
+	// The producer state came along, so retries are still deduplicated.
+	off, err = restored.Append(&api.Record{
+		Value:      []byte("hello world"),
+		ProducerId: 42,
+		Sequence:   5,
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), off)
+}
+
+func testMetrics(t *testing.T, l *Log) {
+	record := &api.Record{Value: []byte("hello world")}
+	for i := 0; i < 3; i++ {
+		_, err := l.Append(record)
+		require.NoError(t, err)
+	}
+	var written uint64
+	for off := uint64(0); off < 3; off++ {
+		read, err := l.Read(off)
+		require.NoError(t, err)
+		written += lenWidth + uint64(proto.Size(read))
+	}
+	require.Equal(t, float64(written), testutil.ToFloat64(bytesWritten.WithLabelValues(l.Dir)))
+	require.Equal(t, float64(len(l.segments)), testutil.ToFloat64(segmentCount.WithLabelValues(l.Dir)))
+	fill := testutil.ToFloat64(activeSegmentFill.WithLabelValues(l.Dir))
+	require.True(t, fill >= 0 && fill <= 1, fill)
+
+	require.NoError(t, l.Truncate(3))
+	require.Equal(t, float64(1), testutil.ToFloat64(truncations.WithLabelValues(l.Dir)))
+	require.Equal(t, float64(len(l.segments)), testutil.ToFloat64(segmentCount.WithLabelValues(l.Dir)))
+}
+
+func TestLogDebugLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-debug-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	core, logs := observer.New(zapcore.DebugLevel)
+	c := Config{Logger: zap.New(core)}
+	c.Segment.MaxStoreBytes = 32
+	l, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	// The record fills the segment.
+	_, err = l.Append(&api.Record{Value: []byte("hello world, hello all")})
+	require.NoError(t, err)
+	require.NoError(t, l.Truncate(1))
+	require.NoError(t, l.Close())
+	_, err = NewLog(dir, c)
+	require.NoError(t, err)
+
+	var messages []string
+	for _, entry := range logs.All() {
+		require.Equal(t, dir, entry.ContextMap()["dir"])
+		messages = append(messages, entry.Message)
+	}
+	require.Equal(t, []string{"recovered log", "rolled segment", "truncated log", "recovered log"}, messages)
+}
+
+func testAppendValidation(t *testing.T, l *Log) {
+	_, err := l.Append(nil)
+	require.Equal(t, api.ErrInvalidRequest{Field: "record", Reason: "is required"}, err)
+
+	// The store's 32 bytes hold a 24 byte record after its length.
+	record := &api.Record{Value: make([]byte, 23)}
+	_, err = l.Append(record)
+	require.Equal(t, api.ErrRecordTooLarge{Size: 25, Max: 24}, err)
+	record.Value = record.Value[:22]
+	_, err = l.Append(record)
+	require.NoError(t, err)
+	require.Len(t, l.segments, 2)
+
+	// The same record no longer fits once it carries a non-zero offset.
+	_, err = l.Append(&api.Record{Value: make([]byte, 22)})
+	require.Equal(t, api.ErrRecordTooLarge{Size: 26, Max: 24}, err)
+}
+
+func testOffsetForTime(t *testing.T, l *Log) {
+	base := time.Unix(1600000000, 0)
+	at := func(seconds int) time.Time {
+		return base.Add(time.Duration(seconds) * time.Second)
+	}
+	// Producers set timestamps, so they needn't be in order.
+	for _, seconds := range []int{3, 1, 5, 2} {
+		_, err := l.Append(&api.Record{Timestamp: timestamppb.New(at(seconds))})
+		require.NoError(t, err)
+	}
+	require.True(t, len(l.segments) > 1)
+	for seconds, want := range map[int]uint64{0: 0, 3: 0, 4: 2, 5: 2, 6: 4} {
+		off, err := l.OffsetForTime(at(seconds))
+		require.NoError(t, err)
+		require.Equal(t, want, off, "at %d seconds", seconds)
+	}
+
+	// Truncated records aren't found, and appended ones are.
+	require.NoError(t, l.Truncate(3))
+	off, err := l.OffsetForTime(at(0))
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+	_, err = l.Append(&api.Record{Timestamp: timestamppb.New(at(10))})
+	require.NoError(t, err)
+	off, err = l.OffsetForTime(at(8))
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), off)
+}
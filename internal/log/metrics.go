@@ -0,0 +1,83 @@
+package log
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// The log's metrics are labeled with the log's directory, since a server
+// keeps several logs, e.g. one per topic and the transaction log.
+var (
+	appendDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "proglog",
+		Subsystem: "log",
+		Name:      "append_duration_seconds",
+		Help:      "Time taken to append a record.",
+		Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+	}, []string{"log"})
+	readDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "proglog",
+		Subsystem: "log",
+		Name:      "read_duration_seconds",
+		Help:      "Time taken to read a record.",
+		Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+	}, []string{"log"})
+	bytesWritten = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "proglog",
+		Subsystem: "log",
+		Name:      "bytes_written_total",
+		Help:      "Bytes appended to the log's stores.",
+	}, []string{"log"})
+	segmentCount = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "proglog",
+		Subsystem: "log",
+		Name:      "segments",
+		Help:      "Number of segments in the log.",
+	}, []string{"log"})
+	activeSegmentFill = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "proglog",
+		Subsystem: "log",
+		Name:      "active_segment_fill_ratio",
+		Help:      "How full the active segment is, from 0 to 1, by whichever of its store and index is fuller.",
+	}, []string{"log"})
+	truncations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "proglog",
+		Subsystem: "log",
+		Name:      "truncations_total",
+		Help:      "Number of times the log was truncated.",
+	}, []string{"log"})
+	truncatedSegments = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "proglog",
+		Subsystem: "log",
+		Name:      "truncated_segments_total",
+		Help:      "Number of segments removed by truncation.",
+	}, []string{"log"})
+)
+
+// updateSegmentMetrics sets the gauges that describe the log's segments. The
+// caller must hold the log's lock.
+func (l *Log) updateSegmentMetrics() {
+	segmentCount.WithLabelValues(l.Dir).Set(float64(len(l.segments)))
+	s := l.activeSegment
+	fill := float64(s.store.size) / float64(s.config.Segment.MaxStoreBytes)
+	if f := float64(s.index.size) / float64(s.config.Segment.MaxIndexBytes); f > fill {
+		fill = f
+	}
+	if fill > 1 {
+		fill = 1
+	}
+	activeSegmentFill.WithLabelValues(l.Dir).Set(fill)
+}
+
+// deleteMetrics removes the log's series so that a closed log doesn't keep
+// reporting its last values.
+func (l *Log) deleteMetrics() {
+	for _, v := range []interface {
+		DeleteLabelValues(...string) bool
+	}{
+		appendDuration, readDuration, bytesWritten, segmentCount,
+		activeSegmentFill, truncations, truncatedSegments,
+	} {
+		v.DeleteLabelValues(l.Dir)
+	}
+}
@@ -0,0 +1,88 @@
+package log
+
+import (
+	"time"
+
+	api "github.com/srikantrao/proglog/api/v1"
+)
+
+// producerWindow is how many of a producer's most recent offsets are
+// remembered for answering retries.
+const producerWindow = 1024
+
+// producerState tracks the latest sequence number appended by an idempotent
+// producer and the offsets of its most recent records, the last of which
+// belongs to LastSequence.
+type producerState struct {
+	LastSequence uint64   `json:"last_sequence"`
+	Offsets      []uint64 `json:"offsets"`
+	// LastAppend is when the producer's latest record was appended, which
+	// decides when it expires.
+	LastAppend time.Time `json:"last_append"`
+}
+
+// producers is the deduplication state of every producer that has written to
+// the log, keyed by producer ID.
+type producers map[uint64]*producerState
+
+// check returns the original offset if the record is a retry of one already
+// appended. A producer the log doesn't know has to start at sequence 1, so
+// that a later batch arriving first is rejected until the earlier ones have
+// been appended.
+func (p producers) check(record *api.Record) (offset uint64, duplicate bool, err error) {
+	state, ok := p[record.ProducerId]
+	if !ok {
+		if record.Sequence != 1 {
+			return 0, false, api.ErrOutOfOrderSequence{
+				ProducerID: record.ProducerId,
+				Sequence:   record.Sequence,
+				Expected:   1,
+			}
+		}
+		return 0, false, nil
+	}
+	switch {
+	case record.Sequence == state.LastSequence+1:
+		return 0, false, nil
+	case record.Sequence > state.LastSequence:
+		return 0, false, api.ErrOutOfOrderSequence{
+			ProducerID: record.ProducerId,
+			Sequence:   record.Sequence,
+			Expected:   state.LastSequence + 1,
+		}
+	}
+	behind := state.LastSequence - record.Sequence
+	if behind >= uint64(len(state.Offsets)) {
+		return 0, false, api.ErrSequenceTooOld{
+			ProducerID: record.ProducerId,
+			Sequence:   record.Sequence,
+		}
+	}
+	return state.Offsets[uint64(len(state.Offsets))-1-behind], true, nil
+}
+
+func (p producers) update(record *api.Record) {
+	state, ok := p[record.ProducerId]
+	if !ok {
+		state = &producerState{}
+		p[record.ProducerId] = state
+	}
+	state.LastSequence = record.Sequence
+	state.LastAppend = time.Now()
+	if record.Timestamp != nil {
+		state.LastAppend = record.Timestamp.AsTime()
+	}
+	state.Offsets = append(state.Offsets, record.Offset)
+	if len(state.Offsets) > producerWindow {
+		state.Offsets = state.Offsets[len(state.Offsets)-producerWindow:]
+	}
+}
+
+// expire forgets the producers that haven't appended since before.
+func (p producers) expire(before time.Time) {
+	for id, state := range p {
+		if state.LastAppend.Before(before) {
+			delete(p, id)
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"google.golang.org/protobuf/proto"
 	"os"
 	"path"
+	"sync"
+	"time"
 	api "github.com/srikantrao/proglog/api/v1"
 )
 
@@ -14,6 +16,13 @@ type segment struct {
 	baseOffset uint64
 	nextOffset uint64
 	config     Config
+
+	latestMu sync.Mutex
+	// latest is the latest timestamp of the segment's records, which
+	// latestTimestamp reads the segment for the first time it's asked and
+	// Append keeps up to date from then on.
+	latest      time.Time
+	latestKnown bool
 }
 
 // newSegment creates a new Segment starting at the baseOffset.
@@ -26,7 +35,7 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 	}
 	// Create the Store.
 	storeFile, err := os.OpenFile(
-		path.Join(dir, fmt.Sprintf("%d%seg", baseOffset, ".store")),
+		segmentPath(dir, baseOffset, ".store"),
 		os.O_RDWR|os.O_CREATE|os.O_APPEND,
 		0644)
 	if err != nil {
@@ -39,7 +48,7 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 
 	// Create the Index
 	indexFile, err := os.OpenFile(
-		path.Join(dir, fmt.Sprintf("%d%seg", baseOffset, ".index")),
+		segmentPath(dir, baseOffset, ".index"),
 		os.O_RDWR|os.O_CREATE,
 		0644)
 	if err != nil {
@@ -80,9 +89,38 @@ func (s *segment) Append(record *api.Record) (uint64, error) {
 		return 0, err
 	}
 	s.nextOffset++
+	if record.Timestamp != nil {
+		s.latestMu.Lock()
+		if ts := record.Timestamp.AsTime(); s.latestKnown && ts.After(s.latest) {
+			s.latest = ts
+		}
+		s.latestMu.Unlock()
+	}
 	return currentOffset, nil
 }
 
+// latestTimestamp returns the latest timestamp of the segment's records, or
+// the zero time if none of them has one.
+func (s *segment) latestTimestamp() (time.Time, error) {
+	s.latestMu.Lock()
+	defer s.latestMu.Unlock()
+	if s.latestKnown {
+		return s.latest, nil
+	}
+	var latest time.Time
+	for off := s.baseOffset; off < s.nextOffset; off++ {
+		record, err := s.Read(off)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if record.Timestamp != nil && record.Timestamp.AsTime().After(latest) {
+			latest = record.Timestamp.AsTime()
+		}
+	}
+	s.latest, s.latestKnown = latest, true
+	return latest, nil
+}
+
 // Read returns the record for the given offset.
 // offset is the absolute offset of the given record.
 // Segment searches for index first and then uses the position in the store to
@@ -101,7 +139,7 @@ func (s *segment) Read(offset uint64) (*api.Record, error) {
 	}
 	record := &api.Record{}
 	if err := proto.Unmarshal(msg, record); err != nil {
-		return nil, err
+		return nil, api.ErrCorruptRecord{Offset: offset, Reason: err.Error()}
 	}
 	return record, nil
 }
@@ -137,6 +175,13 @@ func (s *segment) Close() error {
 	return nil
 }
 
+// segmentPath returns the path of the segment's file with the given
+// extension. The "eg" suffix is an accident of the original format string
+// that existing data directories depend on.
+func segmentPath(dir string, baseOffset uint64, ext string) string {
+	return path.Join(dir, fmt.Sprintf("%d%seg", baseOffset, ext))
+}
+
 // nearestMultiple returns the nearest and lesser multiple of k in j,
 func nearestMultiple(j, k uint64) uint64 {
 	if j >= 0 {
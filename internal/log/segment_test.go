@@ -3,10 +3,14 @@ package log
 import (
 	api "github.com/srikantrao/proglog/api/v1"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"io"
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestSegment(t *testing.T) {
@@ -58,3 +62,70 @@ func TestSegment(t *testing.T) {
 	require.NoError(t, err)
 	require.False(t, s.IsMaxed())
 }
+
+func TestSegmentMetadata(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "segment-test")
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = 1024
+	c.Segment.MaxStoreBytes = 1024
+	s, err := newSegment(dir, 0, c)
+	require.NoError(t, err)
+
+	// A record as written before records carried metadata: just the value
+	// and the offset.
+	var legacy []byte
+	legacy = protowire.AppendTag(legacy, 1, protowire.BytesType)
+	legacy = protowire.AppendBytes(legacy, []byte("legacy"))
+	_, pos, err := s.store.Append(legacy)
+	require.NoError(t, err)
+	require.NoError(t, s.index.Write(0, pos))
+	s.nextOffset++
+
+	want := &api.Record{
+		Value:       []byte(`{"hello":"world"}`),
+		Key:         []byte("key"),
+		Headers:     map[string]string{"trace-id": "abc"},
+		Timestamp:   timestamppb.New(time.Unix(1600000000, 0)),
+		ContentType: "application/json",
+	}
+	off, err := s.Append(want)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+
+	got, err := s.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("legacy"), got.Value)
+	require.Nil(t, got.Key)
+	require.Nil(t, got.Headers)
+	require.Nil(t, got.Timestamp)
+	require.Equal(t, "", got.ContentType)
+
+	got, err = s.Read(1)
+	require.NoError(t, err)
+	require.True(t, proto.Equal(want, got))
+}
+
+func TestSegmentCorruptRecord(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "segment-test")
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = 1024
+	c.Segment.MaxStoreBytes = 1024
+	s, err := newSegment(dir, 0, c)
+	require.NoError(t, err)
+
+	// A length-delimited field that claims more bytes than follow it.
+	_, pos, err := s.store.Append([]byte{0x0a, 0x10, 'x'})
+	require.NoError(t, err)
+	require.NoError(t, s.index.Write(0, pos))
+	s.nextOffset++
+
+	_, err = s.Read(0)
+	apiErr, ok := err.(api.ErrCorruptRecord)
+	require.True(t, ok, "got %v", err)
+	require.Equal(t, uint64(0), apiErr.Offset)
+	require.Equal(t, apiErr, api.DecodeError(apiErr.GRPCStatus().Err()))
+}
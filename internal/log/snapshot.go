@@ -0,0 +1,71 @@
+package log
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+
+	api "github.com/srikantrao/proglog/api/v1"
+)
+
+const snapshotFile = "state.snapshot"
+
+// snapshot is the state the log derives from its records: the producers used
+// to deduplicate retries and the transactions used to hide uncommitted
+// records. It is written to disk whenever a segment is rolled and when the
+// log is closed, and rebuilt on startup by replaying the records appended
+// after it was taken.
+type snapshot struct {
+	// Offset is the log's next offset when the snapshot was taken.
+	Offset       uint64        `json:"offset"`
+	Producers    producers     `json:"producers"`
+	Transactions *transactions `json:"transactions"`
+}
+
+func newSnapshot() *snapshot {
+	return &snapshot{
+		Producers:    make(producers),
+		Transactions: newTransactions(),
+	}
+}
+
+func (s *snapshot) apply(record *api.Record) {
+	if record.ProducerId != 0 {
+		s.Producers.update(record)
+	}
+	if record.TransactionId != 0 {
+		s.Transactions.update(record)
+	}
+}
+
+// save atomically writes the snapshot to dir.
+func (s *snapshot) save(dir string, offset uint64) error {
+	s.Offset = offset
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	name := path.Join(dir, snapshotFile)
+	if err := ioutil.WriteFile(name+".tmp", b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+// loadSnapshot reads the snapshot in dir, returning empty state if there
+// isn't one.
+func loadSnapshot(dir string) (*snapshot, error) {
+	s := newSnapshot()
+	b, err := ioutil.ReadFile(path.Join(dir, snapshotFile))
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -89,6 +89,13 @@ func (s *store) ReadAt(p []byte, off int64) (int, error) {
 	return s.File.ReadAt(p, off)
 }
 
+// Flush writes any buffered data to the file.
+func (s *store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Flush()
+}
+
 // persists any buffered data before closing the store
 func (s *store) Close() error {
 	s.mu.Lock()
@@ -0,0 +1,81 @@
+package log
+
+import (
+	api "github.com/srikantrao/proglog/api/v1"
+)
+
+// abortedTransaction is the range of offsets, up to and including the abort
+// marker, in which the transaction's records must be hidden from
+// read-committed consumers.
+type abortedTransaction struct {
+	FirstOffset uint64 `json:"first_offset"`
+	LastOffset  uint64 `json:"last_offset"`
+}
+
+// transactions tracks the transactions that have written to the log.
+// Committed transactions need no state since their records are visible.
+type transactions struct {
+	// Ongoing maps open transactions to their first offset in the log.
+	Ongoing map[uint64]uint64             `json:"ongoing"`
+	Aborted map[uint64]abortedTransaction `json:"aborted"`
+}
+
+func newTransactions() *transactions {
+	return &transactions{
+		Ongoing: make(map[uint64]uint64),
+		Aborted: make(map[uint64]abortedTransaction),
+	}
+}
+
+func (t *transactions) update(record *api.Record) {
+	id := record.TransactionId
+	switch record.Control {
+	case api.Control_CONTROL_NONE:
+		if _, ok := t.Ongoing[id]; !ok {
+			t.Ongoing[id] = record.Offset
+		}
+	case api.Control_CONTROL_COMMIT:
+		delete(t.Ongoing, id)
+	case api.Control_CONTROL_ABORT:
+		if first, ok := t.Ongoing[id]; ok {
+			t.Aborted[id] = abortedTransaction{
+				FirstOffset: first,
+				LastOffset:  record.Offset,
+			}
+			delete(t.Ongoing, id)
+		}
+	}
+}
+
+// lastStableOffset returns the offset below which every transaction has been
+// decided, nextOffset when no transaction is open.
+func (t *transactions) lastStableOffset(nextOffset uint64) uint64 {
+	lso := nextOffset
+	for _, first := range t.Ongoing {
+		if first < lso {
+			lso = first
+		}
+	}
+	return lso
+}
+
+// visible reports whether a read-committed consumer may see the record.
+func (t *transactions) visible(record *api.Record) bool {
+	if record.Control != api.Control_CONTROL_NONE {
+		return false
+	}
+	if record.TransactionId == 0 {
+		return true
+	}
+	aborted, ok := t.Aborted[record.TransactionId]
+	return !ok || record.Offset < aborted.FirstOffset || record.Offset > aborted.LastOffset
+}
+
+// truncate forgets aborted transactions that ended before lowest.
+func (t *transactions) truncate(lowest uint64) {
+	for id, aborted := range t.Aborted {
+		if aborted.LastOffset < lowest {
+			delete(t.Aborted, id)
+		}
+	}
+}
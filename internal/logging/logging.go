@@ -0,0 +1,82 @@
+// Package logging builds the structured loggers of the server's subsystems,
+// each with a level that can be changed while the server runs.
+package logging
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type Config struct {
+	// Level is the initial level of every subsystem. Defaults to info.
+	Level zapcore.Level
+	// Development switches from JSON to human-readable console output.
+	Development bool
+}
+
+// Logging hands out a logger per subsystem. All of them write to the same
+// sink, but each has its own level.
+type Logging struct {
+	mu      sync.Mutex
+	config  Config
+	encoder zapcore.Encoder
+	sink    zapcore.WriteSyncer
+	levels  map[string]zap.AtomicLevel
+}
+
+// New returns a Logging whose loggers write to sink.
+func New(sink zapcore.WriteSyncer, c Config) *Logging {
+	var encoder zapcore.Encoder
+	if c.Development {
+		encoder = zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	} else {
+		encoder = zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	}
+	return &Logging{
+		config:  c,
+		encoder: encoder,
+		sink:    sink,
+		levels:  make(map[string]zap.AtomicLevel),
+	}
+}
+
+// Logger returns the logger of the subsystem, e.g. "server" or "log".
+func (l *Logging) Logger(subsystem string) *zap.Logger {
+	core := zapcore.NewCore(l.encoder, l.sink, l.level(subsystem))
+	return zap.New(core).Named(subsystem)
+}
+
+// SetLevel changes the level of the subsystem's loggers.
+func (l *Logging) SetLevel(subsystem string, level zapcore.Level) {
+	l.level(subsystem).SetLevel(level)
+}
+
+func (l *Logging) level(subsystem string) zap.AtomicLevel {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	level, ok := l.levels[subsystem]
+	if !ok {
+		level = zap.NewAtomicLevelAt(l.config.Level)
+		l.levels[subsystem] = level
+	}
+	return level
+}
+
+// ServeHTTP gets and sets the level of the subsystem named by the last
+// element of the request path, e.g. /log/level/server, with zap's
+// {"level":"debug"} JSON. Only subsystems that have loggers can be set.
+func (l *Logging) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	subsystem := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	l.mu.Lock()
+	level, ok := l.levels[subsystem]
+	l.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown subsystem "+subsystem, http.StatusNotFound)
+		return
+	}
+	level.ServeHTTP(w, r)
+}
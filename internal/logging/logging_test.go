@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logs := New(zapcore.AddSync(&buf), Config{Level: zapcore.InfoLevel})
+	server, log := logs.Logger("server"), logs.Logger("log")
+
+	lines := func() []map[string]interface{} {
+		var entries []map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			entry := map[string]interface{}{}
+			require.NoError(t, json.Unmarshal([]byte(line), &entry))
+			entries = append(entries, entry)
+		}
+		buf.Reset()
+		return entries
+	}
+
+	server.Debug("hidden")
+	log.Info("shown")
+	entries := lines()
+	require.Len(t, entries, 1)
+	require.Equal(t, "log", entries[0]["logger"])
+
+	// Levels are per subsystem.
+	logs.SetLevel("server", zapcore.DebugLevel)
+	server.Debug("shown")
+	log.Debug("hidden")
+	entries = lines()
+	require.Len(t, entries, 1)
+	require.Equal(t, "server", entries[0]["logger"])
+
+	// And can be changed over HTTP.
+	w := httptest.NewRecorder()
+	logs.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/log/level/log", strings.NewReader(`{"level":"debug"}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	log.Debug("shown")
+	require.Len(t, lines(), 1)
+
+	w = httptest.NewRecorder()
+	logs.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/log/level/nope", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
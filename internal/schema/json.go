@@ -0,0 +1,161 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// jsonSchema is a JSON Schema document. Values are validated against the
+// full specification, but the compatibility check only understands type,
+// properties, required, additionalProperties, enum and items, and treats
+// every other keyword as unconstrained.
+type jsonSchema struct {
+	schema *gojsonschema.Schema
+	doc    map[string]interface{}
+}
+
+func compileJSON(definition []byte) (*jsonSchema, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(definition, &doc); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid JSON schema: %v", err)
+	}
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(definition))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid JSON schema: %v", err)
+	}
+	return &jsonSchema{schema: schema, doc: doc}, nil
+}
+
+func (s *jsonSchema) validate(value []byte) error {
+	res, err := s.schema.Validate(gojsonschema.NewBytesLoader(value))
+	if err != nil {
+		return err
+	}
+	if !res.Valid() {
+		var errs []string
+		for _, e := range res.Errors() {
+			errs = append(errs, e.String())
+		}
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (s *jsonSchema) compatible(writer compiled) error {
+	return checkJSON("$", s.doc, writer.(*jsonSchema).doc)
+}
+
+// checkJSON returns an error if a value valid against writer may be invalid
+// against reader.
+func checkJSON(path string, reader, writer map[string]interface{}) error {
+	if rt := jsonTypes(reader); rt != nil {
+		wt := jsonTypes(writer)
+		if wt == nil {
+			return fmt.Errorf("%s: type restricted to %v", path, keys(rt))
+		}
+		for t := range wt {
+			if !rt[t] && !(t == "integer" && rt["number"]) {
+				return fmt.Errorf("%s: type %s no longer allowed", path, t)
+			}
+		}
+	}
+
+	if re, ok := reader["enum"].([]interface{}); ok {
+		we, ok := writer["enum"].([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: values restricted to an enum", path)
+		}
+		for _, v := range we {
+			if !containsValue(re, v) {
+				return fmt.Errorf("%s: enum value %v removed", path, v)
+			}
+		}
+	}
+
+	rp, _ := reader["properties"].(map[string]interface{})
+	wp, _ := writer["properties"].(map[string]interface{})
+	wr := stringSet(writer["required"])
+	for name := range stringSet(reader["required"]) {
+		if !wr[name] {
+			return fmt.Errorf("%s: property %q is required", path, name)
+		}
+	}
+	for name, r := range rp {
+		w, ok := wp[name]
+		if !ok {
+			continue
+		}
+		rs, rok := r.(map[string]interface{})
+		ws, wok := w.(map[string]interface{})
+		if rok && wok {
+			if err := checkJSON(path+"."+name, rs, ws); err != nil {
+				return err
+			}
+		}
+	}
+	if closed, ok := reader["additionalProperties"].(bool); ok && !closed {
+		for name := range wp {
+			if _, ok := rp[name]; !ok {
+				return fmt.Errorf("%s: property %q no longer allowed", path, name)
+			}
+		}
+		if open, ok := writer["additionalProperties"].(bool); !ok || open {
+			return fmt.Errorf("%s: additional properties no longer allowed", path)
+		}
+	}
+
+	ri, rok := reader["items"].(map[string]interface{})
+	wi, wok := writer["items"].(map[string]interface{})
+	if rok && wok {
+		return checkJSON(path+"[]", ri, wi)
+	}
+	return nil
+}
+
+// jsonTypes returns the set of types the schema allows, nil if it doesn't
+// restrict the type.
+func jsonTypes(schema map[string]interface{}) map[string]bool {
+	switch t := schema["type"].(type) {
+	case string:
+		return map[string]bool{t: true}
+	case []interface{}:
+		return stringSet(t)
+	}
+	return nil
+}
+
+func stringSet(v interface{}) map[string]bool {
+	set := make(map[string]bool)
+	values, _ := v.([]interface{})
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			set[s] = true
+		}
+	}
+	return set
+}
+
+func keys(set map[string]bool) []string {
+	var keys []string
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func containsValue(values []interface{}, v interface{}) bool {
+	for _, value := range values {
+		if reflect.DeepEqual(value, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package schema
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// protobufSchema is a message type that values are encoded as.
+type protobufSchema struct {
+	desc protoreflect.MessageDescriptor
+}
+
+func compileProtobuf(definition []byte, message string) (*protobufSchema, error) {
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(definition, &set); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid protobuf schema: %v", err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid protobuf schema: %v", err)
+	}
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(message))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "message %q not found in protobuf schema", message)
+	}
+	md, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "%q is not a message", message)
+	}
+	return &protobufSchema{desc: md}, nil
+}
+
+func (s *protobufSchema) validate(value []byte) error {
+	return proto.Unmarshal(value, dynamicpb.NewMessage(s.desc))
+}
+
+func (s *protobufSchema) compatible(writer compiled) error {
+	return checkProtobuf(s.desc, writer.(*protobufSchema).desc, make(map[protoreflect.FullName]bool))
+}
+
+// checkProtobuf returns an error if a message encoded with writer may not
+// decode the same way with reader. Fields are matched by number. Fields only
+// one side knows are skipped or left unset, except for proto2 required
+// fields, which the reader must always be given.
+func checkProtobuf(reader, writer protoreflect.MessageDescriptor, seen map[protoreflect.FullName]bool) error {
+	if seen[reader.FullName()] {
+		return nil
+	}
+	seen[reader.FullName()] = true
+	rfields, wfields := reader.Fields(), writer.Fields()
+	for i := 0; i < rfields.Len(); i++ {
+		rf := rfields.Get(i)
+		wf := wfields.ByNumber(rf.Number())
+		if wf == nil {
+			if rf.Cardinality() == protoreflect.Required {
+				return fmt.Errorf("%s: required field %d added", reader.FullName(), rf.Number())
+			}
+			continue
+		}
+		if rf.Cardinality() == protoreflect.Required && wf.Cardinality() != protoreflect.Required {
+			return fmt.Errorf("%s: field %d made required", reader.FullName(), rf.Number())
+		}
+		if rf.IsList() != wf.IsList() || rf.IsMap() != wf.IsMap() {
+			return fmt.Errorf("%s: field %d changed cardinality", reader.FullName(), rf.Number())
+		}
+		if !compatibleKinds(rf.Kind(), wf.Kind()) {
+			return fmt.Errorf("%s: field %d changed from %s to %s",
+				reader.FullName(), rf.Number(), wf.Kind(), rf.Kind())
+		}
+		if rf.Message() != nil && wf.Message() != nil {
+			if err := checkProtobuf(rf.Message(), wf.Message(), seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compatibleKinds reports whether values of kind w decode as kind r, following
+// the protobuf language guide's rules for changing a field's type.
+func compatibleKinds(r, w protoreflect.Kind) bool {
+	if r == w {
+		return true
+	}
+	groups := [][]protoreflect.Kind{{
+		protoreflect.Int32Kind, protoreflect.Uint32Kind,
+		protoreflect.Int64Kind, protoreflect.Uint64Kind,
+		protoreflect.BoolKind, protoreflect.EnumKind,
+	}, {
+		protoreflect.Sint32Kind, protoreflect.Sint64Kind,
+	}, {
+		protoreflect.Fixed32Kind, protoreflect.Sfixed32Kind,
+	}, {
+		protoreflect.Fixed64Kind, protoreflect.Sfixed64Kind,
+	}, {
+		protoreflect.StringKind, protoreflect.BytesKind,
+	}}
+	for _, group := range groups {
+		if containsKind(group, r) && containsKind(group, w) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsKind(kinds []protoreflect.Kind, kind protoreflect.Kind) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,283 @@
+// Package schema implements a registry of versioned schemas that record
+// values can be validated against.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	api "github.com/srikantrao/proglog/api/v1"
+	"github.com/srikantrao/proglog/internal/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type Config struct {
+	// DefaultCompatibility is the compatibility of subjects that haven't
+	// set their own. Defaults to BACKWARD.
+	DefaultCompatibility api.Compatibility
+}
+
+// entry is a record in the registry's log. Every registration records the
+// subject's compatibility level at the time.
+type entry struct {
+	Subject       string            `json:"subject"`
+	Version       uint32            `json:"version"`
+	Type          api.SchemaType    `json:"type"`
+	Definition    []byte            `json:"definition"`
+	Message       string            `json:"message,omitempty"`
+	Compatibility api.Compatibility `json:"compatibility"`
+}
+
+type subject struct {
+	compatibility api.Compatibility
+	versions      []*version
+}
+
+type version struct {
+	schema *api.Schema
+	compiled
+}
+
+// compiled is a parsed schema that values can be validated against.
+type compiled interface {
+	validate(value []byte) error
+	// compatible checks that values written with writer can be read with
+	// the schema, which has the same type.
+	compatible(writer compiled) error
+}
+
+// Registry stores the versions of every subject's schema in a dedicated log.
+type Registry struct {
+	mu       sync.RWMutex
+	log      *log.Log
+	config   Config
+	subjects map[string]*subject
+}
+
+// NewRegistry creates a Registry backed by l, loading the schemas already in
+// the log. Versions truncated from the log are gone, but later versions keep
+// their numbers.
+func NewRegistry(l *log.Log, c Config) (*Registry, error) {
+	if c.DefaultCompatibility == api.Compatibility_COMPATIBILITY_UNSPECIFIED {
+		c.DefaultCompatibility = api.Compatibility_COMPATIBILITY_BACKWARD
+	}
+	r := &Registry{
+		log:      l,
+		config:   c,
+		subjects: make(map[string]*subject),
+	}
+	lowest, err := l.LowestOffset()
+	if err != nil {
+		return nil, err
+	}
+	for off := lowest; ; off++ {
+		record, err := l.Read(off)
+		if _, ok := err.(api.ErrOffsetOutOfRange); ok {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var e entry
+		if err := json.Unmarshal(record.Value, &e); err != nil {
+			return nil, err
+		}
+		if err := r.add(e); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Register adds the schema as the subject's next version and returns the
+// version. compatibility, unless unspecified, first changes the subject's
+// compatibility level. Registering the subject's latest schema again returns
+// its version without adding a new one.
+func (r *Registry) Register(schema *api.Schema, compatibility api.Compatibility) (uint32, error) {
+	if schema == nil || schema.Subject == "" {
+		return 0, status.Error(codes.InvalidArgument, "schema subject is required")
+	}
+	if _, ok := api.Compatibility_name[int32(compatibility)]; !ok {
+		return 0, api.ErrInvalidRequest{
+			Field:  "compatibility",
+			Reason: fmt.Sprintf("unknown compatibility %d", compatibility),
+		}
+	}
+	c, err := compile(schema)
+	if err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.subjects[schema.Subject]
+	if !ok {
+		s = &subject{compatibility: r.config.DefaultCompatibility}
+	}
+	if compatibility == api.Compatibility_COMPATIBILITY_UNSPECIFIED {
+		compatibility = s.compatibility
+	}
+	next := uint32(1)
+	if n := len(s.versions); n > 0 {
+		latest := s.versions[n-1]
+		next = latest.schema.Version + 1
+		if latest.schema.Type == schema.Type &&
+			latest.schema.Message == schema.Message &&
+			bytes.Equal(latest.schema.Definition, schema.Definition) &&
+			compatibility == s.compatibility {
+			return latest.schema.Version, nil
+		}
+		if err := check(schema.Subject, compatibility, latest, c, schema.Type); err != nil {
+			return 0, err
+		}
+	}
+	e := entry{
+		Subject:       schema.Subject,
+		Version:       next,
+		Type:          schema.Type,
+		Definition:    schema.Definition,
+		Message:       schema.Message,
+		Compatibility: compatibility,
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := r.log.Append(&api.Record{Value: b}); err != nil {
+		return 0, err
+	}
+	r.subjects[schema.Subject] = s
+	s.compatibility = compatibility
+	s.versions = append(s.versions, &version{schema: e.schema(), compiled: c})
+	return e.Version, nil
+}
+
+// Get returns the given version of the subject's schema, the latest when
+// version is 0, along with the subject's compatibility level.
+func (r *Registry) Get(subject string, version uint32) (*api.Schema, api.Compatibility, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	v, err := r.version(subject, version)
+	if err != nil {
+		return nil, 0, err
+	}
+	return v.schema, r.subjects[subject].compatibility, nil
+}
+
+// Validate checks the value against the latest version of the subject's
+// schema. Values of subjects without a schema are always valid.
+func (r *Registry) Validate(subject string, value []byte) error {
+	r.mu.RLock()
+	s, ok := r.subjects[subject]
+	var latest *version
+	if ok {
+		latest = s.versions[len(s.versions)-1]
+	}
+	r.mu.RUnlock()
+	if latest == nil {
+		return nil
+	}
+	if err := latest.validate(value); err != nil {
+		return api.ErrInvalidRecord{
+			Subject: subject,
+			Version: latest.schema.Version,
+			Reason:  err.Error(),
+		}
+	}
+	return nil
+}
+
+func (r *Registry) version(subject string, v uint32) (*version, error) {
+	s, ok := r.subjects[subject]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "subject %q not found", subject)
+	}
+	if v == 0 {
+		return s.versions[len(s.versions)-1], nil
+	}
+	// The first versions may have been truncated from the log.
+	first := s.versions[0].schema.Version
+	if v < first || int(v-first) >= len(s.versions) {
+		return nil, status.Errorf(codes.NotFound, "version %d of subject %q not found", v, subject)
+	}
+	return s.versions[v-first], nil
+}
+
+// add loads an entry read back from the log.
+func (r *Registry) add(e entry) error {
+	schema := e.schema()
+	c, err := compile(schema)
+	if err != nil {
+		return err
+	}
+	s, ok := r.subjects[e.Subject]
+	if !ok {
+		s = &subject{}
+		r.subjects[e.Subject] = s
+	}
+	s.compatibility = e.Compatibility
+	s.versions = append(s.versions, &version{schema: schema, compiled: c})
+	return nil
+}
+
+func (e entry) schema() *api.Schema {
+	return &api.Schema{
+		Subject:    e.Subject,
+		Version:    e.Version,
+		Type:       e.Type,
+		Definition: e.Definition,
+		Message:    e.Message,
+	}
+}
+
+func compile(schema *api.Schema) (compiled, error) {
+	switch schema.Type {
+	case api.SchemaType_SCHEMA_TYPE_JSON:
+		return compileJSON(schema.Definition)
+	case api.SchemaType_SCHEMA_TYPE_PROTOBUF:
+		return compileProtobuf(schema.Definition, schema.Message)
+	}
+	return nil, status.Errorf(codes.InvalidArgument, "unsupported schema type %s", schema.Type)
+}
+
+// check returns an error unless the new schema passes the compatibility
+// check against the latest version.
+func check(
+	subject string,
+	compatibility api.Compatibility,
+	latest *version,
+	c compiled,
+	typ api.SchemaType,
+) error {
+	if compatibility == api.Compatibility_COMPATIBILITY_NONE {
+		return nil
+	}
+	incompatible := func(reason string) error {
+		return api.ErrIncompatibleSchema{
+			Subject:       subject,
+			Compatibility: compatibility,
+			Reason:        reason,
+		}
+	}
+	if latest.schema.Type != typ {
+		return incompatible("schema type changed")
+	}
+	backward := compatibility == api.Compatibility_COMPATIBILITY_BACKWARD ||
+		compatibility == api.Compatibility_COMPATIBILITY_FULL
+	forward := compatibility == api.Compatibility_COMPATIBILITY_FORWARD ||
+		compatibility == api.Compatibility_COMPATIBILITY_FULL
+	if backward {
+		if err := c.compatible(latest.compiled); err != nil {
+			return incompatible(err.Error())
+		}
+	}
+	if forward {
+		if err := latest.compatible(c); err != nil {
+			return incompatible(err.Error())
+		}
+	}
+	return nil
+}
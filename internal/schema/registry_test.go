@@ -0,0 +1,336 @@
+package schema
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	api "github.com/srikantrao/proglog/api/v1"
+	"github.com/srikantrao/proglog/internal/log"
+)
+
+const (
+	jsonType     = api.SchemaType_SCHEMA_TYPE_JSON
+	protobufType = api.SchemaType_SCHEMA_TYPE_PROTOBUF
+	backward     = api.Compatibility_COMPATIBILITY_BACKWARD
+	forward      = api.Compatibility_COMPATIBILITY_FORWARD
+	full         = api.Compatibility_COMPATIBILITY_FULL
+	none         = api.Compatibility_COMPATIBILITY_NONE
+)
+
+func TestRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	r, err := NewRegistry(l, Config{})
+	require.NoError(t, err)
+
+	// Subjects without a schema accept anything.
+	require.NoError(t, r.Validate("clicks", []byte("not json")))
+
+	v1 := &api.Schema{
+		Subject:    "clicks",
+		Type:       jsonType,
+		Definition: []byte(`{"type":"object","properties":{"id":{"type":"string"}},"required":["id"]}`),
+	}
+	version, err := r.Register(v1, api.Compatibility_COMPATIBILITY_UNSPECIFIED)
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), version)
+
+	// Registering the latest schema again doesn't add a version.
+	version, err = r.Register(v1, api.Compatibility_COMPATIBILITY_UNSPECIFIED)
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), version)
+
+	require.NoError(t, r.Validate("clicks", []byte(`{"id":"a"}`)))
+	err = r.Validate("clicks", []byte(`{"name":"a"}`))
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.IsType(t, api.ErrInvalidRecord{}, err)
+
+	// New subjects default to backward compatibility, which forbids new
+	// required properties.
+	_, err = r.Register(&api.Schema{
+		Subject:    "clicks",
+		Type:       jsonType,
+		Definition: []byte(`{"type":"object","required":["id","at"]}`),
+	}, api.Compatibility_COMPATIBILITY_UNSPECIFIED)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	v2 := &api.Schema{
+		Subject:    "clicks",
+		Type:       jsonType,
+		Definition: []byte(`{"type":"object","properties":{"id":{"type":"string"},"at":{"type":"integer"}},"required":["id"]}`),
+	}
+	version, err = r.Register(v2, full)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), version)
+
+	_, err = r.Register(&api.Schema{Subject: "clicks", Type: jsonType, Definition: []byte("{")}, none)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// The registry is rebuilt from its log.
+	r, err = NewRegistry(l, Config{})
+	require.NoError(t, err)
+	got, compatibility, err := r.Get("clicks", 0)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), got.Version)
+	require.Equal(t, v2.Definition, got.Definition)
+	require.Equal(t, full, compatibility)
+	got, _, err = r.Get("clicks", 1)
+	require.NoError(t, err)
+	require.Equal(t, v1.Definition, got.Definition)
+
+	_, _, err = r.Get("clicks", 3)
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, _, err = r.Get("views", 0)
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = r.Register(v2, api.Compatibility(42))
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// A registry over a truncated log has the versions left in it, and
+	// numbers new ones after them.
+	require.NoError(t, l.Roll())
+	version, err = r.Register(v2, none)
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), version)
+	require.NoError(t, l.Truncate(3))
+	r, err = NewRegistry(l, Config{})
+	require.NoError(t, err)
+	got, compatibility, err = r.Get("clicks", 0)
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), got.Version)
+	require.Equal(t, none, compatibility)
+	_, _, err = r.Get("clicks", 2)
+	require.Equal(t, codes.NotFound, status.Code(err))
+	version, err = r.Register(v1, none)
+	require.NoError(t, err)
+	require.Equal(t, uint32(4), version)
+	got, _, err = r.Get("clicks", 4)
+	require.NoError(t, err)
+	require.Equal(t, v1.Definition, got.Definition)
+}
+
+func TestJSONCompatibility(t *testing.T) {
+	for scenario, tc := range map[string]struct {
+		compatibility api.Compatibility
+		old, new      string
+		ok            bool
+	}{
+		"adding an optional property": {
+			backward,
+			`{"type":"object","properties":{"a":{"type":"string"}}}`,
+			`{"type":"object","properties":{"a":{"type":"string"},"b":{"type":"string"}}}`,
+			true,
+		},
+		"adding a required property": {
+			backward,
+			`{"type":"object","properties":{"a":{"type":"string"}}}`,
+			`{"type":"object","properties":{"a":{"type":"string"}},"required":["a"]}`,
+			false,
+		},
+		"removing a required property is forward incompatible": {
+			forward,
+			`{"type":"object","required":["a"]}`,
+			`{"type":"object"}`,
+			false,
+		},
+		"removing a required property is backward compatible": {
+			backward,
+			`{"type":"object","required":["a"]}`,
+			`{"type":"object"}`,
+			true,
+		},
+		"changing a property's type": {
+			backward,
+			`{"type":"object","properties":{"a":{"type":"string"}}}`,
+			`{"type":"object","properties":{"a":{"type":"integer"}}}`,
+			false,
+		},
+		"widening integer to number": {
+			backward,
+			`{"type":"object","properties":{"a":{"type":"integer"}}}`,
+			`{"type":"object","properties":{"a":{"type":"number"}}}`,
+			true,
+		},
+		"widening integer to number both ways": {
+			full,
+			`{"type":"object","properties":{"a":{"type":"integer"}}}`,
+			`{"type":"object","properties":{"a":{"type":"number"}}}`,
+			false,
+		},
+		"removing an enum value": {
+			backward,
+			`{"enum":["a","b"]}`,
+			`{"enum":["a"]}`,
+			false,
+		},
+		"closing the content model": {
+			backward,
+			`{"type":"object","properties":{"a":{}}}`,
+			`{"type":"object","properties":{"a":{}},"additionalProperties":false}`,
+			false,
+		},
+		"changing array items": {
+			backward,
+			`{"type":"array","items":{"type":"string"}}`,
+			`{"type":"array","items":{"type":"boolean"}}`,
+			false,
+		},
+		"anything goes without compatibility": {
+			none,
+			`{"type":"string"}`,
+			`{"type":"integer"}`,
+			true,
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			err := register(t, tc.compatibility,
+				&api.Schema{Type: jsonType, Definition: []byte(tc.old)},
+				&api.Schema{Type: jsonType, Definition: []byte(tc.new)},
+			)
+			if tc.ok {
+				require.NoError(t, err)
+			} else {
+				require.IsType(t, api.ErrIncompatibleSchema{}, err)
+			}
+		})
+	}
+}
+
+func TestProtobufCompatibility(t *testing.T) {
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Type:   typ.Enum(),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+	}
+	repeated := func(f *descriptorpb.FieldDescriptorProto) *descriptorpb.FieldDescriptorProto {
+		f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		return f
+	}
+	var (
+		str   = descriptorpb.FieldDescriptorProto_TYPE_STRING
+		bytes = descriptorpb.FieldDescriptorProto_TYPE_BYTES
+		i32   = descriptorpb.FieldDescriptorProto_TYPE_INT32
+		i64   = descriptorpb.FieldDescriptorProto_TYPE_INT64
+		dbl   = descriptorpb.FieldDescriptorProto_TYPE_DOUBLE
+	)
+	for scenario, tc := range map[string]struct {
+		old, new []*descriptorpb.FieldDescriptorProto
+		ok       bool
+	}{
+		"adding a field": {
+			[]*descriptorpb.FieldDescriptorProto{field("a", 1, str)},
+			[]*descriptorpb.FieldDescriptorProto{field("a", 1, str), field("b", 2, i32)},
+			true,
+		},
+		"removing a field": {
+			[]*descriptorpb.FieldDescriptorProto{field("a", 1, str), field("b", 2, i32)},
+			[]*descriptorpb.FieldDescriptorProto{field("a", 1, str)},
+			true,
+		},
+		"renaming a field": {
+			[]*descriptorpb.FieldDescriptorProto{field("a", 1, str)},
+			[]*descriptorpb.FieldDescriptorProto{field("b", 1, str)},
+			true,
+		},
+		"widening int32 to int64": {
+			[]*descriptorpb.FieldDescriptorProto{field("a", 1, i32)},
+			[]*descriptorpb.FieldDescriptorProto{field("a", 1, i64)},
+			true,
+		},
+		"string to bytes": {
+			[]*descriptorpb.FieldDescriptorProto{field("a", 1, str)},
+			[]*descriptorpb.FieldDescriptorProto{field("a", 1, bytes)},
+			true,
+		},
+		"int32 to double": {
+			[]*descriptorpb.FieldDescriptorProto{field("a", 1, i32)},
+			[]*descriptorpb.FieldDescriptorProto{field("a", 1, dbl)},
+			false,
+		},
+		"making a field repeated": {
+			[]*descriptorpb.FieldDescriptorProto{field("a", 1, str)},
+			[]*descriptorpb.FieldDescriptorProto{repeated(field("a", 1, str))},
+			false,
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			err := register(t, full,
+				&api.Schema{Type: protobufType, Definition: protoSchema(t, tc.old), Message: "test.Event"},
+				&api.Schema{Type: protobufType, Definition: protoSchema(t, tc.new), Message: "test.Event"},
+			)
+			if tc.ok {
+				require.NoError(t, err)
+			} else {
+				require.IsType(t, api.ErrIncompatibleSchema{}, err)
+			}
+		})
+	}
+}
+
+func TestProtobufValidation(t *testing.T) {
+	schema, err := compileProtobuf(protoSchema(t, []*descriptorpb.FieldDescriptorProto{{
+		Name:   proto.String("name"),
+		Number: proto.Int32(1),
+		Type:   descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+		Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+	}}), "test.Event")
+	require.NoError(t, err)
+
+	value, err := proto.Marshal(&api.Record{Value: []byte("hello")})
+	require.NoError(t, err)
+	require.NoError(t, schema.validate(value))
+	require.Error(t, schema.validate([]byte{0x0a, 0xff}))
+
+	_, err = compileProtobuf(protoSchema(t, nil), "test.Missing")
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// register registers old and then new under the given compatibility.
+func register(t *testing.T, compatibility api.Compatibility, old, new *api.Schema) error {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "registry-test")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	l, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	r, err := NewRegistry(l, Config{DefaultCompatibility: compatibility})
+	require.NoError(t, err)
+
+	old.Subject, new.Subject = "test", "test"
+	_, err = r.Register(old, api.Compatibility_COMPATIBILITY_UNSPECIFIED)
+	require.NoError(t, err)
+	_, err = r.Register(new, api.Compatibility_COMPATIBILITY_UNSPECIFIED)
+	return err
+}
+
+// protoSchema returns a FileDescriptorSet defining test.Event with fields.
+func protoSchema(t *testing.T, fields []*descriptorpb.FieldDescriptorProto) []byte {
+	t.Helper()
+	b, err := proto.Marshal(&descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{{
+			Name:    proto.String("test.proto"),
+			Package: proto.String("test"),
+			Syntax:  proto.String("proto3"),
+			MessageType: []*descriptorpb.DescriptorProto{{
+				Name:  proto.String("Event"),
+				Field: fields,
+			}},
+		}},
+	})
+	require.NoError(t, err)
+	return b
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+
+	api "github.com/srikantrao/proglog/api/v1"
+)
+
+const adminAction = "admin"
+
+// adminServer implements the Admin service over the same logs as grpcServer.
+type adminServer struct {
+	api.UnimplementedAdminServer
+	*grpcServer
+}
+
+// log authorizes the caller and returns the topic's log.
+func (s *adminServer) log(ctx context.Context, topic string) (CommitLog, error) {
+	if err := s.Authorizer.Enforce(ctx, subject(ctx), objectWildcard, adminAction); err != nil {
+		return nil, err
+	}
+	return s.topic(topic)
+}
+
+func (s *adminServer) GetOffsets(ctx context.Context, req *api.GetOffsetsRequest) (*api.GetOffsetsResponse, error) {
+	log, err := s.log(ctx, req.Topic)
+	if err != nil {
+		return nil, err
+	}
+	lowest, err := log.LowestOffset()
+	if err != nil {
+		return nil, err
+	}
+	highest, err := log.HighestOffset()
+	if err != nil {
+		return nil, err
+	}
+	return &api.GetOffsetsResponse{
+		LowestOffset:  lowest,
+		HighestOffset: highest,
+	}, nil
+}
+
+func (s *adminServer) GetSegments(ctx context.Context, req *api.GetSegmentsRequest) (*api.GetSegmentsResponse, error) {
+	log, err := s.log(ctx, req.Topic)
+	if err != nil {
+		return nil, err
+	}
+	res := &api.GetSegmentsResponse{}
+	for _, info := range log.Segments() {
+		res.Segments = append(res.Segments, &api.Segment{
+			BaseOffset: info.BaseOffset,
+			NextOffset: info.NextOffset,
+			StoreBytes: info.StoreBytes,
+			IndexBytes: info.IndexBytes,
+		})
+	}
+	return res, nil
+}
+
+func (s *adminServer) GetDiskUsage(ctx context.Context, req *api.GetDiskUsageRequest) (*api.GetDiskUsageResponse, error) {
+	log, err := s.log(ctx, req.Topic)
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := log.DiskUsage()
+	if err != nil {
+		return nil, err
+	}
+	return &api.GetDiskUsageResponse{Bytes: bytes}, nil
+}
+
+func (s *adminServer) Truncate(ctx context.Context, req *api.TruncateRequest) (*api.TruncateResponse, error) {
+	log, err := s.log(ctx, req.Topic)
+	if err != nil {
+		return nil, err
+	}
+	if err := log.Truncate(req.Lowest); err != nil {
+		return nil, err
+	}
+	lowest, err := log.LowestOffset()
+	if err != nil {
+		return nil, err
+	}
+	return &api.TruncateResponse{LowestOffset: lowest}, nil
+}
+
+func (s *adminServer) RollSegment(ctx context.Context, req *api.RollSegmentRequest) (*api.RollSegmentResponse, error) {
+	log, err := s.log(ctx, req.Topic)
+	if err != nil {
+		return nil, err
+	}
+	if err := log.Roll(); err != nil {
+		return nil, err
+	}
+	segments := log.Segments()
+	return &api.RollSegmentResponse{
+		BaseOffset: segments[len(segments)-1].BaseOffset,
+	}, nil
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	api "github.com/srikantrao/proglog/api/v1"
+	"github.com/srikantrao/proglog/internal/auth"
+	"github.com/srikantrao/proglog/internal/config"
+	"github.com/srikantrao/proglog/internal/log"
+)
+
+func TestAdmin(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.ServerCertFile,
+		KeyFile:  config.ServerKeyFile,
+		CAFile:   config.CAFile,
+		Server:   true,
+	})
+	require.NoError(t, err)
+	dir, err := ioutil.TempDir("", "admin-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	server, err := NewGRPCServer(&Config{
+		CommitLog:  clog,
+		Authorizer: auth.New(config.ACLModelFile, config.ACLPolicyFile),
+	}, grpc.Creds(credentials.NewTLS(serverTLSConfig)))
+	require.NoError(t, err)
+	go server.Serve(l)
+	defer server.Stop()
+
+	newClient := func(crtPath, keyPath string) (api.LogClient, api.AdminClient) {
+		tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
+			CertFile: crtPath,
+			KeyFile:  keyPath,
+			CAFile:   config.CAFile,
+		})
+		require.NoError(t, err)
+		conn, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return api.NewLogClient(conn), api.NewAdminClient(conn)
+	}
+	client, admin := newClient(config.RootClientCertFile, config.RootClientKeyFile)
+	_, nobody := newClient(config.NobodyClientCertFile, config.NobodyClientKeyFile)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+		roll, err := admin.RollSegment(ctx, &api.RollSegmentRequest{})
+		require.NoError(t, err)
+		require.Equal(t, uint64(i+1), roll.BaseOffset)
+	}
+	// Rolling an empty segment does nothing.
+	roll, err := admin.RollSegment(ctx, &api.RollSegmentRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), roll.BaseOffset)
+
+	offsets, err := admin.GetOffsets(ctx, &api.GetOffsetsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), offsets.LowestOffset)
+	require.Equal(t, uint64(2), offsets.HighestOffset)
+
+	segments, err := admin.GetSegments(ctx, &api.GetSegmentsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, 4, len(segments.Segments))
+	require.Equal(t, uint64(1), segments.Segments[0].NextOffset)
+	require.NotZero(t, segments.Segments[0].StoreBytes)
+	require.Equal(t, uint64(12), segments.Segments[0].IndexBytes)
+
+	usage, err := admin.GetDiskUsage(ctx, &api.GetDiskUsageRequest{})
+	require.NoError(t, err)
+	require.NotZero(t, usage.Bytes)
+
+	// Truncating past the end of the log keeps the active segment.
+	truncate, err := admin.Truncate(ctx, &api.TruncateRequest{Lowest: 100})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), truncate.LowestOffset)
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+
+	_, err = admin.GetSegments(ctx, &api.GetSegmentsRequest{Topic: "missing"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = nobody.Truncate(ctx, &api.TruncateRequest{Lowest: 100})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bytes"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	api "github.com/srikantrao/proglog/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// maxFilterExpressionBytes is the longest filter expression the server
+	// compiles.
+	maxFilterExpressionBytes = 1024
+	// maxFilterCost limits the estimated cost of evaluating a filter
+	// expression, which the server does for every record it reads. Loops
+	// over a record's fields have no bound, so they're over it.
+	maxFilterCost = 100
+)
+
+// filterEnv declares the variables a filter expression can use.
+var filterEnv *cel.Env
+
+func init() {
+	var err error
+	filterEnv, err = cel.NewEnv(
+		cel.Types(&api.Record{}),
+		cel.Declarations(
+			decls.NewVar("record", decls.NewObjectType("log.v1.Record")),
+		),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// filter is a compiled api.Filter.
+type filter struct {
+	headers   map[string]string
+	keyPrefix []byte
+	program   cel.Program
+}
+
+// newFilter compiles f, returning a nil filter, which matches every record,
+// when f is nil.
+func newFilter(f *api.Filter) (*filter, error) {
+	if f == nil {
+		return nil, nil
+	}
+	compiled := &filter{
+		headers:   f.Headers,
+		keyPrefix: f.KeyPrefix,
+	}
+	if f.Expression == "" {
+		return compiled, nil
+	}
+	if len(f.Expression) > maxFilterExpressionBytes {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"filter expression is longer than %d bytes", maxFilterExpressionBytes)
+	}
+	ast, issues := filterEnv.Compile(f.Expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid filter expression: %v", issues.Err())
+	}
+	if !proto.Equal(ast.ResultType(), decls.Bool) {
+		return nil, status.Error(codes.InvalidArgument, "filter expression must evaluate to a bool")
+	}
+	program, err := filterEnv.Program(ast)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid filter expression: %v", err)
+	}
+	if _, max := cel.EstimateCost(program); max > maxFilterCost {
+		return nil, status.Error(codes.InvalidArgument, "filter expression is too expensive to evaluate")
+	}
+	compiled.program = program
+	return compiled, nil
+}
+
+func (f *filter) match(record *api.Record) bool {
+	if f == nil {
+		return true
+	}
+	for k, v := range f.headers {
+		if got, ok := record.Headers[k]; !ok || got != v {
+			return false
+		}
+	}
+	if !bytes.HasPrefix(record.Key, f.keyPrefix) {
+		return false
+	}
+	if f.program == nil {
+		return true
+	}
+	out, _, err := f.program.Eval(map[string]interface{}{"record": record})
+	return err == nil && out == types.True
+}
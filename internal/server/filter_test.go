@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	api "github.com/srikantrao/proglog/api/v1"
+)
+
+func TestFilter(t *testing.T) {
+	record := &api.Record{
+		Value:   []byte("hello world"),
+		Key:     []byte("user-1"),
+		Headers: map[string]string{"type": "click"},
+	}
+	for scenario, tc := range map[string]struct {
+		filter *api.Filter
+		match  bool
+	}{
+		"no filter":              {nil, true},
+		"matching header":        {&api.Filter{Headers: map[string]string{"type": "click"}}, true},
+		"different header value": {&api.Filter{Headers: map[string]string{"type": "view"}}, false},
+		"missing header":         {&api.Filter{Headers: map[string]string{"region": "eu"}}, false},
+		"matching key prefix":    {&api.Filter{KeyPrefix: []byte("user-")}, true},
+		"different key prefix":   {&api.Filter{KeyPrefix: []byte("order-")}, false},
+		"true expression": {&api.Filter{
+			Expression: `record.headers["type"] == "click" && size(record.value) < 1024`,
+		}, true},
+		"false expression":          {&api.Filter{Expression: `record.key == b"user-2"`}, false},
+		"expression that fails":     {&api.Filter{Expression: `record.headers["region"] == "eu"`}, false},
+		"every condition must pass": {&api.Filter{KeyPrefix: []byte("user-"), Expression: "false"}, false},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			f, err := newFilter(tc.filter)
+			require.NoError(t, err)
+			require.Equal(t, tc.match, f.match(record))
+		})
+	}
+
+	for _, expr := range []string{
+		"record.",
+		"record.value",
+		"unknown == 1",
+		// Too long, and too expensive to run for every record.
+		strings.Repeat("true && ", maxFilterExpressionBytes/8) + "true",
+		`record.headers.exists(k, record.headers.all(l, l != k))`,
+	} {
+		_, err := newFilter(&api.Filter{Expression: expr})
+		require.Equal(t, codes.InvalidArgument, status.Code(err), expr)
+	}
+}
+
+func testConsumeStreamFilter(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, typ := range []string{"view", "click", "view", "view", "click"} {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{
+				Value:   []byte(typ),
+				Headers: map[string]string{"type": typ},
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{
+		Filter: &api.Filter{Headers: map[string]string{"type": "click"}},
+	})
+	require.NoError(t, err)
+	for _, want := range []uint64{1, 4} {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, want, res.Record.Offset)
+		require.Equal(t, []byte("click"), res.Record.Value)
+	}
+
+	stream, err = client.ConsumeStream(ctx, &api.ConsumeRequest{
+		Filter: &api.Filter{Expression: "record."},
+	})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,327 @@
+package server
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	api "github.com/srikantrao/proglog/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// consumePollInterval is how long a stream that has caught up with its
+	// log waits before checking it for new records.
+	consumePollInterval = 10 * time.Millisecond
+	// defaultMaxWait is how long a batch short of its min_bytes is held back
+	// when the request doesn't say.
+	defaultMaxWait = 500 * time.Millisecond
+)
+
+// ConsumeFlow authorizes the caller once, like ConsumeStream, and then sends
+// records while the client has credit for them.
+func (s *grpcServer) ConsumeFlow(stream api.Log_ConsumeFlowServer) error {
+	ctx := stream.Context()
+	if err := s.Authorizer.Enforce(ctx, subject(ctx), objectWildcard, consumeAction); err != nil {
+		return err
+	}
+	first, err := stream.Recv()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if first.Consume == nil {
+		return api.ErrInvalidRequest{
+			Field:  "consume",
+			Reason: "is required on the first request",
+		}
+	}
+	c := newCredits(first.Credit)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				c.close(err)
+				return
+			}
+			c.grant(req.Credit)
+		}
+	}()
+	return s.consumeStream(ctx, first.Consume, c, stream.Send)
+}
+
+// consumeStream sends the records of req's topic from its start position
+// onwards until ctx is done, send fails, the server shuts down or, if
+// credits isn't nil, the client stops granting credit. Records are batched
+// as req asks, and no more are sent than credits allow.
+func (s *grpcServer) consumeStream(
+	ctx context.Context,
+	req *api.ConsumeRequest,
+	credits *credits,
+	send func(*api.ConsumeResponse) error,
+) error {
+	filter, err := newFilter(req.Filter)
+	if err != nil {
+		return err
+	}
+	log, err := s.topic(req.Topic)
+	if err != nil {
+		return err
+	}
+	if req.Offset, err = startOffset(log, req); err != nil {
+		return err
+	}
+	b, err := newBatch(req)
+	if err != nil {
+		return err
+	}
+	flush := func() error {
+		if err := send(b.response()); err != nil {
+			return err
+		}
+		s.tracing.consumed(ctx, req.Topic, b.records...)
+		if credits != nil {
+			credits.take(uint64(len(b.records)))
+		}
+		b.reset()
+		return nil
+	}
+	lag, deleteLag := lagGauge(ctx, req.Topic)
+	defer deleteLag()
+	for {
+		if b.full(credits) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		for credits != nil && credits.available() == 0 {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if closed, err := credits.closed(); closed {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-s.Shutdown:
+				return errShuttingDown
+			case <-credits.changed:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.Shutdown:
+			return errShuttingDown
+		default:
+		}
+		res, err := s.consume(req)
+		switch e := err.(type) {
+		case nil:
+		case api.ErrOffsetOutOfRange:
+			lag.Set(0)
+			if e.Offset < e.LowWatermark {
+				// The log was truncated past the stream's position.
+				if req.Offset, err = resetOffset(req.OutOfRangePolicy, e); err != nil {
+					return err
+				}
+				continue
+			}
+			// Caught up: send what there is unless it's to be held back,
+			// then wait for more.
+			if b.ready() {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-s.Shutdown:
+				return errShuttingDown
+			case <-time.After(consumePollInterval):
+			}
+			continue
+		default:
+			return err
+		}
+		if highest, err := log.HighestOffset(); err == nil && highest > res.Record.Offset {
+			lag.Set(float64(highest - res.Record.Offset))
+		} else {
+			lag.Set(0)
+		}
+		if filter.match(res.Record) {
+			if !b.fits(res.Record) {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			b.add(res.Record)
+		}
+		// Read-committed consumers skip over hidden records, and filtered
+		// ones are never sent.
+		req.Offset = res.Record.Offset + 1
+	}
+}
+
+// batch collects the records for a ConsumeStream response.
+type batch struct {
+	// batched is whether the client asked for batches rather than a
+	// response per record.
+	batched    bool
+	maxRecords uint64
+	maxBytes   uint64
+	minBytes   uint64
+	maxWait    time.Duration
+
+	records []*api.Record
+	bytes   uint64
+	started time.Time
+}
+
+func newBatch(req *api.ConsumeRequest) (*batch, error) {
+	b := &batch{
+		batched: req.MaxBatchRecords != 0 ||
+			req.MaxBatchBytes != 0 ||
+			req.MinBytes != 0,
+		maxRecords: 1,
+	}
+	if !b.batched {
+		return b, nil
+	}
+	b.maxRecords = req.MaxBatchRecords
+	b.maxBytes = req.MaxBatchBytes
+	if b.maxBytes == 0 {
+		b.maxBytes = defaultRangeBytes
+	}
+	b.minBytes = req.MinBytes
+	b.maxWait = defaultMaxWait
+	if req.MaxWait != nil {
+		if err := req.MaxWait.CheckValid(); err != nil {
+			return nil, api.ErrInvalidRequest{Field: "max_wait", Reason: err.Error()}
+		}
+		if b.maxWait = req.MaxWait.AsDuration(); b.maxWait < 0 {
+			return nil, api.ErrInvalidRequest{Field: "max_wait", Reason: "must not be negative"}
+		}
+	}
+	return b, nil
+}
+
+// fits reports whether record can join the batch without taking it over
+// its byte limit. Any record fits an empty batch.
+func (b *batch) fits(record *api.Record) bool {
+	return len(b.records) == 0 || b.maxBytes == 0 ||
+		b.bytes+uint64(proto.Size(record)) <= b.maxBytes
+}
+
+func (b *batch) add(record *api.Record) {
+	if len(b.records) == 0 {
+		b.started = time.Now()
+	}
+	b.records = append(b.records, record)
+	b.bytes += uint64(proto.Size(record))
+}
+
+// full reports whether the batch has to be sent before another record is
+// read: it has reached its record or byte limit or used up the client's
+// credit.
+func (b *batch) full(credits *credits) bool {
+	n := uint64(len(b.records))
+	if n == 0 {
+		return false
+	}
+	return (b.maxRecords != 0 && n >= b.maxRecords) ||
+		(b.maxBytes != 0 && b.bytes >= b.maxBytes) ||
+		(credits != nil && n >= credits.available())
+}
+
+// ready reports whether a batch is to be sent once the stream has caught up
+// with the log rather than held back for more records.
+func (b *batch) ready() bool {
+	return len(b.records) > 0 &&
+		(b.bytes >= b.minBytes || time.Since(b.started) >= b.maxWait)
+}
+
+func (b *batch) response() *api.ConsumeResponse {
+	if !b.batched {
+		return &api.ConsumeResponse{Record: b.records[0]}
+	}
+	return &api.ConsumeResponse{Records: b.records}
+}
+
+func (b *batch) reset() {
+	b.records = nil
+	b.bytes = 0
+}
+
+// credits counts the records a ConsumeFlow client has granted the server
+// and the server hasn't sent yet.
+type credits struct {
+	mu  sync.Mutex
+	n   uint64
+	end bool
+	err error
+	// changed is signalled when credit is granted or the client stops
+	// granting it.
+	changed chan struct{}
+}
+
+func newCredits(n uint64) *credits {
+	return &credits{
+		n:       n,
+		changed: make(chan struct{}, 1),
+	}
+}
+
+func (c *credits) grant(n uint64) {
+	c.mu.Lock()
+	c.n += n
+	c.mu.Unlock()
+	c.signal()
+}
+
+func (c *credits) take(n uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n > c.n {
+		n = c.n
+	}
+	c.n -= n
+}
+
+func (c *credits) available() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
+
+// close records that the client won't grant more credit because its side of
+// the stream ended with err, which is io.EOF if it closed it.
+func (c *credits) close(err error) {
+	c.mu.Lock()
+	c.end = true
+	if err != io.EOF {
+		c.err = err
+	}
+	c.mu.Unlock()
+	c.signal()
+}
+
+// closed reports whether the client has stopped granting credit and, if its
+// side of the stream failed, why.
+func (c *credits) closed() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.end, c.err
+}
+
+func (c *credits) signal() {
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
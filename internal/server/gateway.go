@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	api "github.com/srikantrao/proglog/api/v1"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	gatewayBufferSize = 1 << 20
+	// Metadata keys the gateway uses to pass the HTTP caller's identity to
+	// the gRPC server. They are only trusted on the in-process connection.
+	gatewaySubjectKey = "proglog-gateway-subject"
+	gatewayPeerKey    = "proglog-gateway-peer"
+)
+
+// GatewayServer serves the gRPC API and a JSON gateway generated from the
+// protos in api/v1 on the same address.
+type GatewayServer struct {
+	*http.Server
+	// grpc serves gRPC clients through ServeHTTP and gateway serves the
+	// gateway's in-process connection, conn.
+	grpc    *grpc.Server
+	gateway *grpc.Server
+	conn    *grpc.ClientConn
+
+	mu       sync.Mutex
+	closing  bool
+	requests sync.WaitGroup
+}
+
+// NewGatewayServer returns a server that serves the gRPC API and the JSON
+// gateway. gRPC requests are recognized by their content type, everything
+// else is handled by the gateway, which calls the gRPC server over an
+// in-process connection on behalf of the authenticated HTTP client.
+// Streaming RPCs use newline delimited JSON. Without a tlsConfig the server
+// speaks h2c.
+func NewGatewayServer(addr string, config *Config, tlsConfig *tls.Config) (*GatewayServer, error) {
+	srv, err := newgrpcServer(config)
+	if err != nil {
+		return nil, err
+	}
+	s := &GatewayServer{
+		grpc:    srv.newServer(),
+		gateway: srv.newServer(grpc.Creds(gatewayCredentials{})),
+	}
+	lis := bufconn.Listen(gatewayBufferSize)
+	go func() {
+		s.gateway.Serve(lis)
+	}()
+	s.conn, err = grpc.Dial(
+		"gateway",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithTransportCredentials(gatewayCredentials{}),
+	)
+	if err != nil {
+		s.gateway.Stop()
+		return nil, err
+	}
+	gwmux := runtime.NewServeMux(
+		runtime.WithMetadata(gatewayMetadata),
+		runtime.WithIncomingHeaderMatcher(gatewayHeaderMatcher),
+	)
+	for _, register := range []func(context.Context, *runtime.ServeMux, *grpc.ClientConn) error{
+		api.RegisterLogHandler,
+		api.RegisterAdminHandler,
+	} {
+		if err := register(context.Background(), gwmux, s.conn); err != nil {
+			s.conn.Close()
+			s.gateway.Stop()
+			return nil, err
+		}
+	}
+
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.begin() {
+			w.Header().Set("Connection", "close")
+			http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		defer s.requests.Done()
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			s.grpc.ServeHTTP(w, r)
+			return
+		}
+		gwmux.ServeHTTP(w, r)
+	})
+	if tlsConfig == nil {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+	s.Server = &http.Server{
+		Addr:      addr,
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+	}
+	return s, nil
+}
+
+// begin counts a request in unless the server is shutting down.
+func (s *GatewayServer) begin() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return false
+	}
+	s.requests.Add(1)
+	return true
+}
+
+// Shutdown stops the server like http.Server.Shutdown, and also waits for
+// the requests in flight on connections it doesn't track, such as h2c ones,
+// before closing the gateway's connection to the gRPC server. If ctx is done
+// first, the remaining requests are cut short.
+func (s *GatewayServer) Shutdown(ctx context.Context) error {
+	err := s.Server.Shutdown(ctx)
+	s.mu.Lock()
+	s.closing = true
+	s.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		s.requests.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		// Nothing is left in flight, so stopping the gateway's server
+		// gracefully is immediate.
+		s.gateway.GracefulStop()
+	case <-ctx.Done():
+		if err == nil {
+			err = ctx.Err()
+		}
+		s.gateway.Stop()
+	}
+	// Transports made by ServeHTTP can't be drained, which GracefulStop
+	// would do, but by now their requests are done or out of time.
+	s.grpc.Stop()
+	s.conn.Close()
+	return err
+}
+
+// gatewayMetadata forwards the HTTP client's identity to the gRPC server.
+func gatewayMetadata(ctx context.Context, r *http.Request) metadata.MD {
+	return metadata.Pairs(
+		gatewaySubjectKey, httpSubject(r),
+		gatewayPeerKey, r.RemoteAddr,
+	)
+}
+
+// gatewayHeaderMatcher forwards headers to the gRPC server like the
+// gateway's default matcher, except for the metadata carrying the HTTP
+// client's identity, which only gatewayMetadata may set.
+func gatewayHeaderMatcher(key string) (string, bool) {
+	if strings.HasPrefix(strings.ToLower(key), strings.ToLower(runtime.MetadataHeaderPrefix)+"proglog-gateway-") {
+		return "", false
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// authenticateGateway replaces the in-process peer with the HTTP client the
+// gateway is calling on behalf of.
+func authenticateGateway(ctx context.Context, p *peer.Peer) context.Context {
+	var subject string
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(gatewaySubjectKey); len(v) > 0 {
+		subject = v[0]
+	}
+	if v := md.Get(gatewayPeerKey); len(v) > 0 {
+		if addr, err := net.ResolveTCPAddr("tcp", v[0]); err == nil {
+			ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr, AuthInfo: p.AuthInfo})
+		}
+	}
+	return context.WithValue(ctx, subjectContextKey{}, subject)
+}
+
+// gatewayCredentials marks connections between the gateway and the gRPC
+// server it was created with. They never leave the process so no handshake
+// is needed.
+type gatewayCredentials struct{}
+
+type gatewayAuthInfo struct{}
+
+func (gatewayAuthInfo) AuthType() string {
+	return "gateway"
+}
+
+func (gatewayCredentials) ClientHandshake(_ context.Context, _ string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return conn, gatewayAuthInfo{}, nil
+}
+
+func (gatewayCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return conn, gatewayAuthInfo{}, nil
+}
+
+func (gatewayCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "gateway"}
+}
+
+func (c gatewayCredentials) Clone() credentials.TransportCredentials {
+	return c
+}
+
+func (gatewayCredentials) OverrideServerName(string) error {
+	return nil
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	api "github.com/srikantrao/proglog/api/v1"
+	"github.com/srikantrao/proglog/internal/auth"
+	"github.com/srikantrao/proglog/internal/config"
+	"github.com/srikantrao/proglog/internal/log"
+	"github.com/srikantrao/proglog/internal/schema"
+)
+
+func TestGatewayServer(t *testing.T) {
+	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.ServerCertFile,
+		KeyFile:  config.ServerKeyFile,
+		CAFile:   config.CAFile,
+		Server:   true,
+	})
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "gateway-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	schemaDir, err := ioutil.TempDir("", "gateway-test-schemas")
+	require.NoError(t, err)
+	defer os.RemoveAll(schemaDir)
+	schemaLog, err := log.NewLog(schemaDir, log.Config{})
+	require.NoError(t, err)
+	schemas, err := schema.NewRegistry(schemaLog, schema.Config{})
+	require.NoError(t, err)
+	cfg := &Config{
+		CommitLog:  clog,
+		Authorizer: auth.New(config.ACLModelFile, config.ACLPolicyFile),
+		Schemas:    schemas,
+	}
+
+	srv, err := NewGatewayServer("", cfg, serverTLSConfig)
+	require.NoError(t, err)
+	ts := httptest.NewUnstartedServer(srv.Handler)
+	ts.EnableHTTP2 = true
+	ts.TLS = srv.TLSConfig
+	ts.StartTLS()
+	defer ts.Close()
+
+	rootTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.RootClientCertFile,
+		KeyFile:  config.RootClientKeyFile,
+		CAFile:   config.CAFile,
+	})
+	require.NoError(t, err)
+	root := &http.Client{Transport: &http.Transport{TLSClientConfig: rootTLSConfig}}
+
+	// Produce over JSON.
+	res, err := root.Post(ts.URL+"/v1/records", "application/json",
+		strings.NewReader(`{"record": {"value": "aGVsbG8gd29ybGQ="}}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var produce struct {
+		Offset string `json:"offset"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&produce))
+	res.Body.Close()
+	require.Equal(t, "0", produce.Offset)
+
+	// Consume over gRPC on the same port.
+	conn, err := grpc.Dial(
+		strings.TrimPrefix(ts.URL, "https://"),
+		grpc.WithTransportCredentials(credentials.NewTLS(rootTLSConfig)),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	consume, err := api.NewLogClient(conn).Consume(context.Background(), &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello world"), consume.Record.Value)
+
+	// Consume over JSON, single record and as a stream.
+	res, err = root.Get(ts.URL + "/v1/records/0")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	res.Body.Close()
+
+	res, err = root.Get(ts.URL + "/v1/records:stream?offset=0")
+	require.NoError(t, err)
+	line, err := bufio.NewReader(res.Body).ReadBytes('\n')
+	require.NoError(t, err)
+	res.Body.Close()
+	var streamed struct {
+		Result struct {
+			Record struct {
+				Value []byte `json:"value"`
+			} `json:"record"`
+		} `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal(line, &streamed))
+	require.Equal(t, []byte("hello world"), streamed.Result.Record.Value)
+
+	// Past the end of the log.
+	res, err = root.Get(ts.URL + "/v1/records/1")
+	require.NoError(t, err)
+	require.NotEqual(t, http.StatusOK, res.StatusCode)
+	res.Body.Close()
+
+	// Register a schema and read back the latest version.
+	res, err = root.Post(ts.URL+"/v1/subjects/clicks/versions", "application/json",
+		strings.NewReader(`{"schema": {"type": "SCHEMA_TYPE_JSON", "definition": "e30="}}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	res.Body.Close()
+	res, err = root.Get(ts.URL + "/v1/subjects/clicks/versions/latest")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var latest struct {
+		Schema struct {
+			Subject string `json:"subject"`
+			Version int    `json:"version"`
+		} `json:"schema"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&latest))
+	res.Body.Close()
+	require.Equal(t, "clicks", latest.Schema.Subject)
+	require.Equal(t, 1, latest.Schema.Version)
+
+	// The gateway authorizes as the HTTP client, not as itself.
+	nobodyTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.NobodyClientCertFile,
+		KeyFile:  config.NobodyClientKeyFile,
+		CAFile:   config.CAFile,
+	})
+	require.NoError(t, err)
+	nobody := &http.Client{Transport: &http.Transport{TLSClientConfig: nobodyTLSConfig}}
+	res, err = nobody.Post(ts.URL+"/v1/records", "application/json",
+		strings.NewReader(`{"record": {"value": "aGVsbG8gd29ybGQ="}}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+	res.Body.Close()
+
+	// Clients can't pass themselves off as someone else through the
+	// metadata the gateway uses for their identity.
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/v1/records",
+		strings.NewReader(`{"record": {"value": "aGVsbG8gd29ybGQ="}}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Grpc-Metadata-Proglog-Gateway-Subject", "root")
+	req.Header.Set("Grpc-Metadata-Proglog-Gateway-Peer", "10.0.0.1:1234")
+	res, err = nobody.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+	res.Body.Close()
+}
+
+func TestGatewayServerShutdown(t *testing.T) {
+	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.ServerCertFile,
+		KeyFile:  config.ServerKeyFile,
+		CAFile:   config.CAFile,
+		Server:   true,
+	})
+	require.NoError(t, err)
+	dir, err := ioutil.TempDir("", "gateway-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	_, err = clog.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	shutdown := make(chan struct{})
+	srv, err := NewGatewayServer("", &Config{
+		CommitLog:  clog,
+		Authorizer: auth.New(config.ACLModelFile, config.ACLPolicyFile),
+		Shutdown:   shutdown,
+	}, serverTLSConfig)
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.ServeTLS(l, "", "")
+
+	rootTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.RootClientCertFile,
+		KeyFile:  config.RootClientKeyFile,
+		CAFile:   config.CAFile,
+	})
+	require.NoError(t, err)
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(rootTLSConfig)))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// A gRPC stream and a gateway stream are in flight.
+	watch, err := healthpb.NewHealthClient(conn).Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	res, err := watch.Recv()
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, res.Status)
+	root := &http.Client{Transport: &http.Transport{TLSClientConfig: rootTLSConfig}}
+	stream, err := root.Get("https://" + l.Addr().String() + "/v1/records:stream?offset=0")
+	require.NoError(t, err)
+	defer stream.Body.Close()
+	require.Equal(t, http.StatusOK, stream.StatusCode)
+
+	close(shutdown)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, srv.Shutdown(ctx))
+
+	res, err = watch.Recv()
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, res.Status)
+	_, err = watch.Recv()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// healthWatchInterval is how often Watch re-checks the server's health.
+const healthWatchInterval = time.Second
+
+// checker is implemented by logs that can report whether they're able to
+// take appends, like *log.Log.
+type checker interface {
+	Check() error
+}
+
+// healthServer implements the standard gRPC health service. Rather than
+// being told about status changes, it checks the server's condition whenever
+// it's asked, so it never reports a stale status.
+type healthServer struct {
+	healthpb.UnimplementedHealthServer
+	*grpcServer
+
+	mu sync.Mutex
+	// notServing is whether the last status was NOT_SERVING, so that only
+	// changes of status are logged.
+	notServing bool
+}
+
+// services are the names the health service answers for. The empty name
+// stands for the server as a whole.
+var services = map[string]bool{
+	"":             true,
+	"log.v1.Log":   true,
+	"log.v1.Admin": true,
+}
+
+func (s *healthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if !services[req.Service] {
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.Service)
+	}
+	return &healthpb.HealthCheckResponse{Status: s.status()}, nil
+}
+
+func (s *healthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	send := func(st healthpb.HealthCheckResponse_ServingStatus) error {
+		return stream.Send(&healthpb.HealthCheckResponse{Status: st})
+	}
+	if !services[req.Service] {
+		// As the spec asks, unknown services are reported rather than
+		// failing the call.
+		if err := send(healthpb.HealthCheckResponse_SERVICE_UNKNOWN); err != nil {
+			return err
+		}
+		<-stream.Context().Done()
+		return nil
+	}
+	last := s.status()
+	if err := send(last); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(healthWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.Shutdown:
+			// Report the shutdown right away, then end the stream so that
+			// it doesn't hold up the server's graceful stop.
+			if last != healthpb.HealthCheckResponse_NOT_SERVING {
+				if err := send(healthpb.HealthCheckResponse_NOT_SERVING); err != nil {
+					return err
+				}
+			}
+			return errShuttingDown
+		case <-ticker.C:
+		}
+		if st := s.status(); st != last {
+			if err := send(st); err != nil {
+				return err
+			}
+			last = st
+		}
+	}
+}
+
+func (s *healthServer) status() healthpb.HealthCheckResponse_ServingStatus {
+	err := s.healthy()
+	s.mu.Lock()
+	changed := s.notServing != (err != nil)
+	s.notServing = err != nil
+	s.mu.Unlock()
+	if err != nil {
+		if changed {
+			s.logger.Warn("not serving", zap.Error(err))
+		}
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	if changed {
+		s.logger.Info("serving")
+	}
+	return healthpb.HealthCheckResponse_SERVING
+}
+
+// healthy returns why the server can't serve requests, if it can't: it's
+// shutting down, one of its logs can't take appends or it isn't ready.
+func (s *grpcServer) healthy() error {
+	if s.shuttingDown() {
+		return fmt.Errorf("shutting down")
+	}
+	logs := map[string]CommitLog{"": s.CommitLog}
+	for name, log := range s.Topics {
+		logs[name] = log
+	}
+	if s.TransactionLog != nil {
+		logs["transactions"] = s.TransactionLog
+	}
+	for name, log := range logs {
+		c, ok := log.(checker)
+		if !ok {
+			continue
+		}
+		if err := c.Check(); err != nil {
+			return fmt.Errorf("log %q: %w", name, err)
+		}
+	}
+	if s.Ready != nil {
+		return s.Ready()
+	}
+	return nil
+}
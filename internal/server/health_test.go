@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/srikantrao/proglog/internal/auth"
+	"github.com/srikantrao/proglog/internal/config"
+	"github.com/srikantrao/proglog/internal/log"
+)
+
+func TestHealth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "health-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var notReady error
+	shutdown := make(chan struct{})
+	core, logs := observer.New(zapcore.InfoLevel)
+	gsrv, err := NewGRPCServer(&Config{
+		Logger:     zap.New(core),
+		CommitLog:  clog,
+		Authorizer: auth.New(config.ACLModelFile, config.ACLPolicyFile),
+		Ready: func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			return notReady
+		},
+		Shutdown: shutdown,
+	})
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go gsrv.Serve(l)
+	defer gsrv.Stop()
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+	ctx := context.Background()
+
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		res, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		return res.Status
+	}
+	serving, notServing := healthpb.HealthCheckResponse_SERVING, healthpb.HealthCheckResponse_NOT_SERVING
+
+	require.Equal(t, serving, check(""))
+	require.Equal(t, serving, check("log.v1.Log"))
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "nope"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	mu.Lock()
+	notReady = errors.New("replication is behind")
+	mu.Unlock()
+	require.Equal(t, notServing, check(""))
+	require.Equal(t, notServing, check(""))
+	mu.Lock()
+	notReady = nil
+	mu.Unlock()
+	require.Equal(t, serving, check(""))
+	// Only the changes of status are logged, not every check.
+	require.Equal(t, 1, logs.FilterMessage("not serving").Len())
+	require.Equal(t, 1, logs.FilterMessage("serving").Len())
+
+	watch, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	res, err := watch.Recv()
+	require.NoError(t, err)
+	require.Equal(t, serving, res.Status)
+	close(shutdown)
+	res, err = watch.Recv()
+	require.NoError(t, err)
+	require.Equal(t, notServing, res.Status)
+	require.Equal(t, notServing, check("log.v1.Log"))
+}
+
+func TestHealthClosedLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "health-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	srv, err := newgrpcServer(&Config{CommitLog: clog})
+	require.NoError(t, err)
+
+	require.NoError(t, srv.healthy())
+	require.NoError(t, clog.Close())
+	require.Error(t, srv.healthy())
+}
@@ -1,13 +1,64 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
-	"github.com/gorilla/mux"
+	"net"
 	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	api "github.com/srikantrao/proglog/api/v1"
+	"github.com/srikantrao/proglog/internal/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// httpServer exposes the same CommitLog and Authorizer as grpcServer over a
+// JSON API.
 type httpServer struct {
-	Log *Log
+	*Config
+}
+
+type Record struct {
+	Value       []byte            `json:"value"`
+	Offset      uint64            `json:"offset"`
+	Key         []byte            `json:"key,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Timestamp   *time.Time        `json:"timestamp,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+}
+
+func newRecord(record *api.Record) Record {
+	r := Record{
+		Value:       record.Value,
+		Offset:      record.Offset,
+		Key:         record.Key,
+		Headers:     record.Headers,
+		ContentType: record.ContentType,
+	}
+	if record.Timestamp != nil {
+		t := record.Timestamp.AsTime()
+		r.Timestamp = &t
+	}
+	return r
+}
+
+func (r Record) proto() *api.Record {
+	record := &api.Record{
+		Value:       r.Value,
+		Key:         r.Key,
+		Headers:     r.Headers,
+		ContentType: r.ContentType,
+	}
+	if r.Timestamp != nil {
+		record.Timestamp = timestamppb.New(*r.Timestamp)
+	}
+	return record
 }
 
 type ProduceRequest struct {
@@ -26,22 +77,27 @@ type ConsumeResponse struct {
 	Record Record `json:"record"`
 }
 
-func newHttpServer() *httpServer {
+func newHttpServer(config *Config) *httpServer {
 	return &httpServer{
-		Log: NewLog(),
+		Config: config,
 	}
 }
 
-func NewHTTPServer(addr string) *http.Server {
-	httpServer := newHttpServer()
+// NewHTTPServer returns a server for the JSON API. When tlsConfig is set the
+// caller should start it with ListenAndServeTLS("", "") so that clients are
+// authenticated with the same certificates as the gRPC server.
+func NewHTTPServer(addr string, config *Config, tlsConfig *tls.Config) *http.Server {
+	httpServer := newHttpServer(config)
 	router := mux.NewRouter()
 
 	// Add the routes
 	router.HandleFunc("/", httpServer.handleProduce).Methods("POST")
 	router.HandleFunc("/", httpServer.handleConsume).Methods("GET")
+	router.HandleFunc("/tail", httpServer.handleTail).Methods("GET")
 	return &http.Server{
-		Addr:    addr,
-		Handler: router,
+		Addr:      addr,
+		Handler:   router,
+		TLSConfig: tlsConfig,
 	}
 }
 
@@ -51,10 +107,27 @@ func (s *httpServer) handleProduce(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	ctx := s.authContext(r, "/log.v1.Log/Produce")
+	if err := s.Authorizer.Enforce(ctx, httpSubject(r), objectWildcard, produceAction); err != nil {
+		writeError(w, err)
+		return
+	}
 	// Append to the log
-	offset, err := s.Log.Append(produceRequest.Record)
+	req := &api.ProduceRequest{Record: produceRequest.Record.proto()}
+	if !s.admit(w, httpSubject(r), produceAction, proto.Size(req)) {
+		return
+	}
+	if err := s.validateProduce(req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.validate("", req.Record); err != nil {
+		writeError(w, err)
+		return
+	}
+	offset, err := s.CommitLog.Append(req.Record)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 	response := ProduceResponse{Offset: offset}
@@ -73,16 +146,23 @@ func (s *httpServer) handleConsume(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	record, err := s.Log.Read(req.Offset)
-	if err == ErrOffsetNotFound {
-		http.Error(w, err.Error(), http.StatusNotFound)
+	ctx := s.authContext(r, "/log.v1.Log/Consume")
+	if err := s.Authorizer.Enforce(ctx, httpSubject(r), objectWildcard, consumeAction); err != nil {
+		writeError(w, err)
+		return
+	}
+	if !s.admit(w, httpSubject(r), consumeAction, 0) {
 		return
 	}
+	record, err := s.CommitLog.ReadCommitted(req.Offset)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
-	res := ConsumeResponse{Record: record}
+	if s.Quotas != nil {
+		s.Quotas.Charge(httpSubject(r), consumeAction, proto.Size(&api.ConsumeResponse{Record: record}))
+	}
+	res := ConsumeResponse{Record: newRecord(record)}
 	err = json.NewEncoder(w).Encode(res)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -90,3 +170,61 @@ func (s *httpServer) handleConsume(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// admit takes a request of n bytes from the subject's quota for action, as
+// the gRPC server does. If the quota is used up it replies 429 Too Many
+// Requests with a Retry-After header and returns false.
+func (s *httpServer) admit(w http.ResponseWriter, subject, action string, n int) bool {
+	if s.Quotas == nil {
+		return true
+	}
+	d := s.Quotas.Admit(subject, action, n)
+	if d <= 0 {
+		return true
+	}
+	w.Header().Set("Retry-After", retryAfter(d))
+	writeError(w, api.ErrQuotaExceeded{Subject: subject, Action: action, RetryAfter: d})
+	return false
+}
+
+// authContext attaches the caller's address and the equivalent gRPC method to
+// the request context so that authorization decisions are audited the same
+// way for both servers.
+func (s *httpServer) authContext(r *http.Request, rpc string) context.Context {
+	ctx := auth.NewRPCContext(r.Context(), rpc)
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+	}
+	return ctx
+}
+
+// httpSubject returns the common name of the client's verified certificate.
+func httpSubject(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+// writeError maps errors from the log and authorizer to HTTP status codes.
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	if _, ok := err.(api.ErrOffsetOutOfRange); ok {
+		code = http.StatusNotFound
+	} else {
+		switch status.Code(err) {
+		case codes.InvalidArgument:
+			code = http.StatusBadRequest
+		case codes.NotFound:
+			code = http.StatusNotFound
+		case codes.PermissionDenied:
+			code = http.StatusForbidden
+		case codes.Unauthenticated:
+			code = http.StatusUnauthorized
+		case codes.ResourceExhausted:
+			code = http.StatusTooManyRequests
+		case codes.Unavailable:
+			code = http.StatusServiceUnavailable
+		}
+	}
+	http.Error(w, err.Error(), code)
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/srikantrao/proglog/internal/auth"
+	"github.com/srikantrao/proglog/internal/config"
+	"github.com/srikantrao/proglog/internal/log"
+)
+
+func TestHTTPServer(t *testing.T) {
+	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.ServerCertFile,
+		KeyFile:  config.ServerKeyFile,
+		CAFile:   config.CAFile,
+		Server:   true,
+	})
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "http-server-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	cfg := &Config{
+		CommitLog:  clog,
+		Authorizer: auth.New(config.ACLModelFile, config.ACLPolicyFile),
+	}
+
+	srv := NewHTTPServer("", cfg, serverTLSConfig)
+	ts := httptest.NewUnstartedServer(srv.Handler)
+	ts.TLS = srv.TLSConfig
+	ts.StartTLS()
+	defer ts.Close()
+
+	newClient := func(crtPath, keyPath string) *http.Client {
+		tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
+			CertFile: crtPath,
+			KeyFile:  keyPath,
+			CAFile:   config.CAFile,
+		})
+		require.NoError(t, err)
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	}
+	root := newClient(config.RootClientCertFile, config.RootClientKeyFile)
+	nobody := newClient(config.NobodyClientCertFile, config.NobodyClientKeyFile)
+
+	do := func(c *http.Client, method string, body interface{}) *http.Response {
+		b, err := json.Marshal(body)
+		require.NoError(t, err)
+		req, err := http.NewRequest(method, ts.URL, bytes.NewReader(b))
+		require.NoError(t, err)
+		res, err := c.Do(req)
+		require.NoError(t, err)
+		return res
+	}
+
+	res := do(root, "POST", ProduceRequest{Record: Record{Value: []byte("hello world")}})
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var produce ProduceResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&produce))
+	res.Body.Close()
+
+	res = do(root, "GET", ConsumeRequest{Offset: produce.Offset})
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var consume ConsumeResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&consume))
+	res.Body.Close()
+	require.Equal(t, []byte("hello world"), consume.Record.Value)
+	require.Equal(t, produce.Offset, consume.Record.Offset)
+
+	// The record survives reopening the log.
+	require.NoError(t, clog.Close())
+	cfg.CommitLog, err = log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	res = do(root, "GET", ConsumeRequest{Offset: produce.Offset})
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	res.Body.Close()
+
+	res = do(root, "GET", ConsumeRequest{Offset: produce.Offset + 1})
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+	res.Body.Close()
+
+	res = do(nobody, "POST", ProduceRequest{Record: Record{Value: []byte("hello world")}})
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+	res.Body.Close()
+
+	// Records are validated as they are by the gRPC server.
+	cfg.MaxRecordBytes = 8
+	res = do(root, "POST", ProduceRequest{Record: Record{Value: []byte("hello world")}})
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	res.Body.Close()
+	cfg.MaxRecordBytes = 0
+
+	// And quotas apply the same way.
+	cfg.Quotas = auth.NewQuotas(map[string]map[string]auth.Quota{
+		"root": {
+			produceAction: {RequestsPerSecond: 1},
+			consumeAction: {RequestsPerSecond: 1},
+		},
+	})
+	for _, req := range []struct {
+		method string
+		body   interface{}
+	}{
+		{"POST", ProduceRequest{Record: Record{Value: []byte("hello world")}}},
+		{"GET", ConsumeRequest{Offset: produce.Offset}},
+	} {
+		res = do(root, req.method, req.body)
+		require.Equal(t, http.StatusOK, res.StatusCode, req.method)
+		res.Body.Close()
+		res = do(root, req.method, req.body)
+		require.Equal(t, http.StatusTooManyRequests, res.StatusCode, req.method)
+		require.Equal(t, "1", res.Header.Get("Retry-After"))
+		res.Body.Close()
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// requestIDKey is the metadata key of the request ID. Callers may set it to
+// correlate their own logs with the server's; otherwise the server makes one
+// up. Either way it's returned in the response header.
+const requestIDKey = "x-request-id"
+
+type requestIDContextKey struct{}
+
+// requestID returns the ID of the RPC that ctx belongs to.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// withRequestID stores the caller's request ID, or a new one, in the
+// context.
+func withRequestID(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var id string
+	if v := md.Get(requestIDKey); len(v) > 0 && v[0] != "" {
+		id = v[0]
+	} else {
+		b := make([]byte, 8)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+// offsetter is implemented by the requests and responses that carry an
+// offset, e.g. ConsumeRequest and ProduceResponse.
+type offsetter interface {
+	GetOffset() uint64
+}
+
+func (s *grpcServer) logUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	ctx = withRequestID(ctx)
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, requestID(ctx)))
+	res, err := handler(ctx, req)
+	fields := rpcFields(ctx, info.FullMethod, start, err)
+	if o, ok := req.(offsetter); ok {
+		fields = append(fields, zap.Uint64("offset", o.GetOffset()))
+	}
+	if o, ok := res.(offsetter); ok && err == nil {
+		fields = append(fields, zap.Uint64("offset", o.GetOffset()))
+	}
+	s.logger.Check(rpcLevel(err), "rpc").Write(fields...)
+	return res, err
+}
+
+func (s *grpcServer) logStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx := withRequestID(ss.Context())
+	ss.SetHeader(metadata.Pairs(requestIDKey, requestID(ctx)))
+	err := handler(srv, &wrappedStream{ServerStream: ss, ctx: ctx})
+	s.logger.Check(rpcLevel(err), "stream").Write(rpcFields(ctx, info.FullMethod, start, err)...)
+	return err
+}
+
+func rpcFields(ctx context.Context, method string, start time.Time, err error) []zapcore.Field {
+	fields := []zapcore.Field{
+		zap.String("method", method),
+		zap.String("request_id", requestID(ctx)),
+		zap.String("subject", subject(ctx)),
+		zap.String("code", status.Code(err).String()),
+		zap.Duration("duration", time.Since(start)),
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		fields = append(fields, zap.String("peer", p.Addr.String()))
+	}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+	return fields
+}
+
+// rpcLevel logs the RPCs that failed because of the server at error level and
+// the rest, including those rejected because of the request, at info level.
+func rpcLevel(err error) zapcore.Level {
+	switch status.Code(err) {
+	case codes.Unknown, codes.Internal, codes.DataLoss, codes.Unavailable:
+		return zapcore.ErrorLevel
+	}
+	return zapcore.InfoLevel
+}
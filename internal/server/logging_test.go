@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	api "github.com/srikantrao/proglog/api/v1"
+)
+
+func TestRPCLogging(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	client, nobody, _, teardown := setupTest(t, func(config *Config) {
+		config.Logger = zap.New(core)
+	})
+	defer teardown()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), requestIDKey, "request-1")
+	var header metadata.MD
+	_, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	}, grpc.Header(&header))
+	require.NoError(t, err)
+	require.Equal(t, []string{"request-1"}, header.Get(requestIDKey))
+
+	_, err = nobody.Consume(context.Background(), &api.ConsumeRequest{Offset: 0}, grpc.Header(&header))
+	require.Error(t, err)
+	generated := header.Get(requestIDKey)
+	require.Len(t, generated, 1)
+	require.NotEmpty(t, generated[0])
+
+	entries := logs.All()
+	require.Len(t, entries, 2)
+	produce := entries[0].ContextMap()
+	require.Equal(t, "/log.v1.Log/Produce", produce["method"])
+	require.Equal(t, "request-1", produce["request_id"])
+	require.Equal(t, "root", produce["subject"])
+	require.Equal(t, codes.OK.String(), produce["code"])
+	require.Equal(t, uint64(0), produce["offset"])
+	require.Contains(t, produce, "duration")
+	require.Contains(t, produce, "peer")
+
+	consume := entries[1].ContextMap()
+	require.Equal(t, generated[0], consume["request_id"])
+	require.Equal(t, "nobody", consume["subject"])
+	require.Equal(t, codes.PermissionDenied.String(), consume["code"])
+}
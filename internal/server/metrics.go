@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	rpcsHandled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "proglog",
+		Subsystem: "server",
+		Name:      "rpcs_handled_total",
+		Help:      "RPCs completed, by method and status code.",
+	}, []string{"method", "code"})
+	activeStreams = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "proglog",
+		Subsystem: "server",
+		Name:      "active_streams",
+		Help:      "Streaming RPCs in progress, by method.",
+	}, []string{"method"})
+	consumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "proglog",
+		Subsystem: "server",
+		Name:      "consumer_lag",
+		Help:      "Records between a consuming stream's position and the end of its topic.",
+	}, []string{"topic", "consumer"})
+)
+
+// NewMetricsServer returns a server that serves the metrics of the process in
+// the Prometheus text format on /metrics and, if logLevels isn't nil, hands
+// requests for /log/level/<subsystem> to it. The server has no TLS or
+// authentication, so addr should be reachable by operators only.
+func NewMetricsServer(addr string, logLevels http.Handler) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	if logLevels != nil {
+		mux.Handle("/log/level/", logLevels)
+	}
+	return &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+}
+
+func metricsUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	res, err := handler(ctx, req)
+	rpcsHandled.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+	return res, err
+}
+
+func metricsStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	streams := activeStreams.WithLabelValues(info.FullMethod)
+	streams.Inc()
+	err := handler(srv, ss)
+	streams.Dec()
+	rpcsHandled.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+	return err
+}
+
+// lagGauge returns the consumer lag gauge for a stream of the topic. The
+// consumer is identified by its subject and address, and the caller deletes
+// the series with the returned func when the stream ends.
+func lagGauge(ctx context.Context, topic string) (prometheus.Gauge, func()) {
+	consumer := subject(ctx)
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		consumer += "@" + p.Addr.String()
+	}
+	return consumerLag.WithLabelValues(topic, consumer), func() {
+		consumerLag.DeleteLabelValues(topic, consumer)
+	}
+}
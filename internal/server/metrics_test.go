@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	api "github.com/srikantrao/proglog/api/v1"
+)
+
+func testMetrics(t *testing.T, client, nobody api.LogClient, config *Config) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	produced := rpcsHandled.WithLabelValues("/log.v1.Log/Produce", codes.OK.String())
+	denied := rpcsHandled.WithLabelValues("/log.v1.Log/Produce", codes.PermissionDenied.String())
+	before, deniedBefore := testutil.ToFloat64(produced), testutil.ToFloat64(denied)
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+	}
+	_, err := nobody.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.Error(t, err)
+	require.Equal(t, before+3, testutil.ToFloat64(produced))
+	require.Equal(t, deniedBefore+1, testutil.ToFloat64(denied))
+
+	streams := activeStreams.WithLabelValues("/log.v1.Log/ConsumeStream")
+	streamsBefore := testutil.ToFloat64(streams)
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := stream.Recv()
+		require.NoError(t, err)
+	}
+	require.Equal(t, streamsBefore+1, testutil.ToFloat64(streams))
+	// The stream has caught up with the log.
+	require.Eventually(t, func() bool {
+		return testutil.CollectAndCount(consumerLag) == 1 &&
+			testutil.ToFloat64(consumerLag) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// Ending the stream removes its lag.
+	cancel()
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(streams) == streamsBefore &&
+			testutil.CollectAndCount(consumerLag) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	srv := NewMetricsServer("", nil)
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	b, err := ioutil.ReadAll(w.Body)
+	require.NoError(t, err)
+	for _, name := range []string{
+		"proglog_server_rpcs_handled_total",
+		"proglog_log_append_duration_seconds",
+		"proglog_log_segments",
+	} {
+		require.True(t, strings.Contains(string(b), name), name)
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"fmt"
+
+	api "github.com/srikantrao/proglog/api/v1"
+)
+
+// startOffset returns the offset a consume request starts reading log at,
+// applying the request's out-of-range policy if its start position is
+// outside the log.
+func startOffset(log CommitLog, req *api.ConsumeRequest) (uint64, error) {
+	low, high := watermarks(log)
+	var off uint64
+	switch req.StartPosition {
+	case api.StartPosition_START_POSITION_OFFSET:
+		off = req.Offset
+	case api.StartPosition_START_POSITION_EARLIEST:
+		off = low
+	case api.StartPosition_START_POSITION_LATEST:
+		off = high
+	case api.StartPosition_START_POSITION_TIMESTAMP:
+		if req.StartTimestamp == nil {
+			return 0, api.ErrInvalidRequest{
+				Field:  "start_timestamp",
+				Reason: "is required to start at a timestamp",
+			}
+		}
+		var err error
+		if off, err = log.OffsetForTime(req.StartTimestamp.AsTime()); err != nil {
+			return 0, err
+		}
+	case api.StartPosition_START_POSITION_FROM_END:
+		off = low
+		if req.FromEnd < high-low {
+			off = high - req.FromEnd
+		}
+	default:
+		return 0, api.ErrInvalidRequest{
+			Field:  "start_position",
+			Reason: fmt.Sprintf("unknown start position %d", req.StartPosition),
+		}
+	}
+	// The end of the log is in range: reads there wait for the next record.
+	if low <= off && off <= high {
+		return off, nil
+	}
+	return resetOffset(req.OutOfRangePolicy, api.ErrOffsetOutOfRange{
+		Offset:        off,
+		LowWatermark:  low,
+		HighWatermark: high,
+	})
+}
+
+// resetOffset returns where to continue reading after err according to the
+// policy, or err if the policy is to fail.
+func resetOffset(policy api.OutOfRangePolicy, err api.ErrOffsetOutOfRange) (uint64, error) {
+	switch policy {
+	case api.OutOfRangePolicy_OUT_OF_RANGE_POLICY_EARLIEST:
+		return err.LowWatermark, nil
+	case api.OutOfRangePolicy_OUT_OF_RANGE_POLICY_LATEST:
+		return err.HighWatermark, nil
+	}
+	return 0, err
+}
+
+// watermarks returns the lowest offset in the log and the offset the next
+// record appended to it gets.
+func watermarks(log CommitLog) (low, high uint64) {
+	segments := log.Segments()
+	return segments[0].BaseOffset, segments[len(segments)-1].NextOffset
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	api "github.com/srikantrao/proglog/api/v1"
+	"github.com/srikantrao/proglog/internal/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// retryAfterKey is the trailer that tells clients whose request was rejected
+// for exceeding their quota how many seconds to wait before retrying. The
+// status details carry the exact delay.
+const retryAfterKey = "retry-after"
+
+// quotaActions are the quota actions the RPCs count against.
+var quotaActions = map[string]string{
+	"/log.v1.Log/Produce":       produceAction,
+	"/log.v1.Log/ProduceStream": produceAction,
+	"/log.v1.Log/Consume":       consumeAction,
+	"/log.v1.Log/ConsumeStream": consumeAction,
+	"/log.v1.Log/ConsumeFlow":   consumeAction,
+	"/log.v1.Log/ConsumeRange":  consumeAction,
+}
+
+// quotaUnary rejects requests that exceed the caller's quota. Produced bytes
+// are counted up front, consumed bytes once the response is known, so a
+// large read makes the caller's next reads wait.
+func (s *grpcServer) quotaUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	action, ok := quotaActions[info.FullMethod]
+	if !ok || s.Quotas == nil {
+		return handler(ctx, req)
+	}
+	subject := subject(ctx)
+	var n int
+	if action == produceAction {
+		n = proto.Size(req.(proto.Message))
+	}
+	if d := s.Quotas.Admit(subject, action, n); d > 0 {
+		grpc.SetTrailer(ctx, metadata.Pairs(retryAfterKey, retryAfter(d)))
+		return nil, api.ErrQuotaExceeded{Subject: subject, Action: action, RetryAfter: d}
+	}
+	res, err := handler(ctx, req)
+	if err == nil && action == consumeAction {
+		s.Quotas.Charge(subject, action, proto.Size(res.(proto.Message)))
+	}
+	return res, err
+}
+
+// retryAfter formats d as the whole number of seconds clients are told to
+// wait before retrying.
+func retryAfter(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// quotaStream slows streams down to the caller's quota rather than ending
+// them.
+func (s *grpcServer) quotaStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	action, ok := quotaActions[info.FullMethod]
+	if !ok || s.Quotas == nil {
+		return handler(srv, ss)
+	}
+	return handler(srv, &throttledStream{
+		ServerStream: ss,
+		quotas:       s.Quotas,
+		subject:      subject(ss.Context()),
+		action:       action,
+	})
+}
+
+// throttledStream waits for quota before passing on the records a producer
+// sends or before sending records to a consumer.
+type throttledStream struct {
+	grpc.ServerStream
+	quotas  *auth.Quotas
+	subject string
+	action  string
+}
+
+func (s *throttledStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if s.action != produceAction {
+		return nil
+	}
+	return s.quotas.Wait(s.Context(), s.subject, s.action, proto.Size(m.(proto.Message)))
+}
+
+func (s *throttledStream) SendMsg(m interface{}) error {
+	if s.action == consumeAction {
+		if err := s.quotas.Wait(s.Context(), s.subject, s.action, proto.Size(m.(proto.Message))); err != nil {
+			return err
+		}
+	}
+	return s.ServerStream.SendMsg(m)
+}
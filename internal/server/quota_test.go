@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	api "github.com/srikantrao/proglog/api/v1"
+	"github.com/srikantrao/proglog/internal/auth"
+)
+
+func TestQuotas(t *testing.T) {
+	client, _, _, teardown := setupTest(t, func(config *Config) {
+		config.Quotas = auth.NewQuotas(map[string]map[string]auth.Quota{
+			"root": {produceAction: {RequestsPerSecond: 1}},
+		})
+	})
+	defer teardown()
+	ctx := context.Background()
+	produce := func() (metadata.MD, error) {
+		var trailer metadata.MD
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("hello world")},
+		}, grpc.Trailer(&trailer))
+		return trailer, err
+	}
+
+	_, err := produce()
+	require.NoError(t, err)
+	trailer, err := produce()
+	st := status.Convert(err)
+	require.Equal(t, codes.ResourceExhausted, st.Code())
+	require.Equal(t, []string{"1"}, trailer.Get(retryAfterKey))
+	quotaErr, ok := api.DecodeError(err).(api.ErrQuotaExceeded)
+	require.True(t, ok, "got %v", err)
+	require.Equal(t, "produce", quotaErr.Action)
+	require.True(t, quotaErr.RetryAfter > 0)
+
+	// Consuming isn't limited.
+	for i := 0; i < 3; i++ {
+		_, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+		require.NoError(t, err)
+	}
+}
@@ -4,43 +4,442 @@ import (
 	"context"
 	api "github.com/srikantrao/proglog/api/v1"
 	"github.com/srikantrao/proglog/internal/auth"
+	commitlog "github.com/srikantrao/proglog/internal/log"
+	"github.com/srikantrao/proglog/internal/schema"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"time"
+)
+
+const (
+	objectWildcard = "*"
+	produceAction  = "produce"
+	consumeAction  = "consume"
 )
 
 type CommitLog interface {
 	Append(record *api.Record) (uint64, error)
 	Read(offset uint64) (*api.Record, error)
+	ReadCommitted(offset uint64) (*api.Record, error)
+	Iterator(from, to uint64, reverse bool) *commitlog.Iterator
+	CommittedIterator(from, to uint64, reverse bool) *commitlog.Iterator
+	OffsetForTime(t time.Time) (uint64, error)
+	LowestOffset() (uint64, error)
+	HighestOffset() (uint64, error)
+	Segments() []commitlog.SegmentInfo
+	DiskUsage() (uint64, error)
+	Truncate(lowest uint64) error
+	Roll() error
+}
+
+// GetServerer reports the servers in the cluster so clients can discover
+// them through the GetServers RPC.
+type GetServerer interface {
+	GetServers() ([]*api.Server, error)
 }
 
 type Config struct {
-	CommitLog  CommitLog
-	Authorizer *auth.Authorizer
+	// CommitLog is the log used by requests that don't name a topic.
+	CommitLog CommitLog
+	// Topics are additional logs requests can name.
+	Topics      map[string]CommitLog
+	Authorizer  *auth.Authorizer
+	GetServerer GetServerer
+	// Quotas, if set, limits the rate at which each subject produces and
+	// consumes.
+	Quotas *auth.Quotas
+	// MaxRecordBytes, if set, is the size of the largest record Produce
+	// accepts. The logs enforce their own limits regardless.
+	MaxRecordBytes uint64
+	// TransactionLog stores the state of transactions. Transactions are
+	// unavailable without it.
+	TransactionLog CommitLog
+	// TransactionTimeout is how long a transaction may stay open before it
+	// is aborted. Defaults to a minute.
+	TransactionTimeout time.Duration
+	// Schemas, if set, validates produced records against the schema
+	// registered for their topic.
+	Schemas *schema.Registry
+	// TracerProvider creates the spans of RPCs and consumed records.
+	// Defaults to the global provider.
+	TracerProvider trace.TracerProvider
+	// Logger logs every RPC. Defaults to a no-op logger.
+	Logger *zap.Logger
+	// Ready, if set, reports whether the server is ready to serve beyond
+	// the state of its logs, e.g. whether replication has caught up. The
+	// health service reports the server as not serving while it returns an
+	// error.
+	Ready func() error
+	// Shutdown is closed when the server starts shutting down. From then on
+	// the health service reports the server as not serving, new RPCs are
+	// rejected and streams end with Unavailable so that their clients
+	// reconnect elsewhere, while RPCs already in flight finish. Stop the
+	// gRPC server gracefully afterwards and then close the logs.
+	Shutdown <-chan struct{}
 }
 
 type grpcServer struct {
 	api.UnimplementedLogServer
 	*Config
+	transactions *coordinator
+	tracing      *tracing
+	logger       *zap.Logger
 }
 
 func NewGRPCServer(config *Config, opts ...grpc.ServerOption) (*grpc.Server, error) {
-	gsrv := grpc.NewServer(opts...)
 	srv, err := newgrpcServer(config)
 	if err != nil {
 		return nil, err
 	}
-	api.RegisterLogServer(gsrv, srv)
-	return gsrv, nil
+	return srv.newServer(opts...), nil
+}
+
+// newServer returns a grpc.Server that serves the Log, Admin and health
+// services with s.
+func (s *grpcServer) newServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(
+			metricsUnary,
+			s.tracing.unary,
+			authenticateUnary,
+			s.logUnary,
+			s.shutdownUnary,
+			s.quotaUnary,
+		),
+		grpc.ChainStreamInterceptor(
+			metricsStream,
+			s.tracing.stream,
+			authenticateStream,
+			s.logStream,
+			s.shutdownStream,
+			s.quotaStream,
+		),
+	)
+	gsrv := grpc.NewServer(opts...)
+	api.RegisterLogServer(gsrv, s)
+	api.RegisterAdminServer(gsrv, &adminServer{grpcServer: s})
+	healthpb.RegisterHealthServer(gsrv, &healthServer{grpcServer: s})
+	return gsrv
 }
 
 func newgrpcServer(config *Config) (*grpcServer, error) {
 	srv := &grpcServer{
-		Config: config,
+		Config:  config,
+		tracing: newTracing(config.TracerProvider),
+		logger:  config.Logger,
+	}
+	if srv.logger == nil {
+		srv.logger = zap.NewNop()
+	}
+	if config.TransactionLog != nil {
+		var err error
+		srv.transactions, err = newCoordinator(
+			config.TransactionLog,
+			srv.topic,
+			config.TransactionTimeout,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if config.Shutdown != nil {
+			go func() {
+				<-config.Shutdown
+				srv.transactions.stop()
+			}()
+		}
 	}
 	return srv, nil
 }
 
 func (s *grpcServer) Produce(ctx context.Context, req *api.ProduceRequest) (*api.ProduceResponse, error) {
-	offset, err := s.CommitLog.Append(req.Record)
+	if err := s.Authorizer.Enforce(ctx, subject(ctx), objectWildcard, produceAction); err != nil {
+		return nil, err
+	}
+	return s.produce(ctx, req)
+}
+
+func (s *grpcServer) Consume(ctx context.Context, req *api.ConsumeRequest) (*api.ConsumeResponse, error) {
+	if err := s.Authorizer.Enforce(ctx, subject(ctx), objectWildcard, consumeAction); err != nil {
+		return nil, err
+	}
+	log, err := s.topic(req.Topic)
+	if err != nil {
+		return nil, err
+	}
+	if req.Offset, err = startOffset(log, req); err != nil {
+		return nil, err
+	}
+	res, err := s.consume(req)
+	if err != nil {
+		return nil, err
+	}
+	s.tracing.consumed(ctx, req.Topic, res.Record)
+	return res, nil
+}
+
+// ProduceStream authorizes the caller once for the lifetime of the stream
+// rather than for every record.
+func (s *grpcServer) ProduceStream(stream api.Log_ProduceStreamServer) error {
+	ctx := stream.Context()
+	if err := s.Authorizer.Enforce(ctx, subject(ctx), objectWildcard, produceAction); err != nil {
+		return err
+	}
+	// Receive in the background so that a shutdown doesn't have to wait
+	// for the client's next request.
+	reqs := make(chan *api.ProduceRequest)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case reqs <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-s.Shutdown:
+			return errShuttingDown
+		case err := <-errs:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case req := <-reqs:
+			res, err := s.produce(ctx, req)
+			if err != nil {
+				return err
+			}
+			if err = stream.Send(res); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *grpcServer) ConsumeStream(req *api.ConsumeRequest, stream api.Log_ConsumeStreamServer) error {
+	ctx := stream.Context()
+	if err := s.Authorizer.Enforce(ctx, subject(ctx), objectWildcard, consumeAction); err != nil {
+		return err
+	}
+	return s.consumeStream(ctx, req, nil, stream.Send)
+}
+
+// defaultRangeBytes is the ConsumeRange budget when the request doesn't set
+// one.
+const defaultRangeBytes = 1 << 20
+
+func (s *grpcServer) ConsumeRange(ctx context.Context, req *api.ConsumeRangeRequest) (*api.ConsumeRangeResponse, error) {
+	if err := s.Authorizer.Enforce(ctx, subject(ctx), objectWildcard, consumeAction); err != nil {
+		return nil, err
+	}
+	log, err := s.topic(req.Topic)
+	if err != nil {
+		return nil, err
+	}
+	var it *commitlog.Iterator
+	if req.ReadUncommitted {
+		it = log.Iterator(req.From, req.To, req.Reverse)
+	} else {
+		it = log.CommittedIterator(req.From, req.To, req.Reverse)
+	}
+	maxBytes := req.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = defaultRangeBytes
+	}
+	res := &api.ConsumeRangeResponse{}
+	var size uint64
+	for it.Next() {
+		record := it.Record()
+		size += uint64(proto.Size(record))
+		if len(res.Records) > 0 && size > maxBytes {
+			// Leave the record for the next request.
+			res.More = true
+			res.NextOffset = record.Offset
+			if req.Reverse {
+				res.NextOffset++
+			}
+			s.tracing.consumed(ctx, req.Topic, res.Records...)
+			return res, nil
+		}
+		res.Records = append(res.Records, record)
+		if req.MaxRecords != 0 && uint64(len(res.Records)) == req.MaxRecords {
+			res.More = it.Next()
+			break
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	res.NextOffset = req.From
+	if req.Reverse {
+		res.NextOffset = req.To
+	}
+	if n := len(res.Records); n > 0 {
+		res.NextOffset = res.Records[n-1].Offset
+		if !req.Reverse {
+			res.NextOffset++
+		}
+	}
+	s.tracing.consumed(ctx, req.Topic, res.Records...)
+	return res, nil
+}
+
+func (s *grpcServer) GetServers(ctx context.Context, req *api.GetServersRequest) (*api.GetServersResponse, error) {
+	if s.GetServerer == nil {
+		return nil, status.Error(codes.Unimplemented, "server discovery is not configured")
+	}
+	servers, err := s.GetServerer.GetServers()
+	if err != nil {
+		return nil, err
+	}
+	return &api.GetServersResponse{Servers: servers}, nil
+}
+
+func (s *grpcServer) BeginTransaction(ctx context.Context, req *api.BeginTransactionRequest) (*api.BeginTransactionResponse, error) {
+	if err := s.Authorizer.Enforce(ctx, subject(ctx), objectWildcard, produceAction); err != nil {
+		return nil, err
+	}
+	if s.transactions == nil {
+		return nil, errTransactionsUnavailable
+	}
+	id, err := s.transactions.begin()
+	if err != nil {
+		return nil, err
+	}
+	return &api.BeginTransactionResponse{TransactionId: id}, nil
+}
+
+func (s *grpcServer) CommitTransaction(ctx context.Context, req *api.CommitTransactionRequest) (*api.CommitTransactionResponse, error) {
+	if err := s.Authorizer.Enforce(ctx, subject(ctx), objectWildcard, produceAction); err != nil {
+		return nil, err
+	}
+	if s.transactions == nil {
+		return nil, errTransactionsUnavailable
+	}
+	if err := s.transactions.commit(req.TransactionId); err != nil {
+		return nil, err
+	}
+	return &api.CommitTransactionResponse{}, nil
+}
+
+func (s *grpcServer) AbortTransaction(ctx context.Context, req *api.AbortTransactionRequest) (*api.AbortTransactionResponse, error) {
+	if err := s.Authorizer.Enforce(ctx, subject(ctx), objectWildcard, produceAction); err != nil {
+		return nil, err
+	}
+	if s.transactions == nil {
+		return nil, errTransactionsUnavailable
+	}
+	if err := s.transactions.abort(req.TransactionId); err != nil {
+		return nil, err
+	}
+	return &api.AbortTransactionResponse{}, nil
+}
+
+// RegisterSchema is an admin action: a new schema or compatibility level
+// applies to every producer and consumer of the topic.
+func (s *grpcServer) RegisterSchema(ctx context.Context, req *api.RegisterSchemaRequest) (*api.RegisterSchemaResponse, error) {
+	if err := s.Authorizer.Enforce(ctx, subject(ctx), objectWildcard, adminAction); err != nil {
+		return nil, err
+	}
+	if s.Schemas == nil {
+		return nil, errSchemasUnavailable
+	}
+	version, err := s.Schemas.Register(req.Schema, req.Compatibility)
+	if err != nil {
+		return nil, err
+	}
+	return &api.RegisterSchemaResponse{Version: version}, nil
+}
+
+func (s *grpcServer) GetSchema(ctx context.Context, req *api.GetSchemaRequest) (*api.GetSchemaResponse, error) {
+	if err := s.Authorizer.Enforce(ctx, subject(ctx), objectWildcard, consumeAction); err != nil {
+		return nil, err
+	}
+	if s.Schemas == nil {
+		return nil, errSchemasUnavailable
+	}
+	found, compatibility, err := s.Schemas.Get(req.Subject, req.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &api.GetSchemaResponse{
+		Schema:        found,
+		Compatibility: compatibility,
+	}, nil
+}
+
+var errSchemasUnavailable = status.Error(codes.Unimplemented, "schema registry is not configured")
+
+var errTransactionsUnavailable = status.Error(codes.Unimplemented, "transactions are not configured")
+
+// defaultSubject is the schema subject of the default log.
+const defaultSubject = "default"
+
+// validate checks the record against the schema registered for the topic.
+func (c *Config) validate(topic string, record *api.Record) error {
+	if c.Schemas == nil {
+		return nil
+	}
+	if topic == "" {
+		topic = defaultSubject
+	}
+	return c.Schemas.Validate(topic, record.Value)
+}
+
+// topic returns the log with the given name, the default log for "".
+func (s *grpcServer) topic(name string) (CommitLog, error) {
+	if name == "" {
+		return s.CommitLog, nil
+	}
+	if log, ok := s.Topics[name]; ok {
+		return log, nil
+	}
+	return nil, api.ErrTopicNotFound{Topic: name}
+}
+
+func (s *grpcServer) produce(ctx context.Context, req *api.ProduceRequest) (*api.ProduceResponse, error) {
+	if err := s.validateProduce(req); err != nil {
+		return nil, err
+	}
+	if req.ProducerId != 0 {
+		req.Record.ProducerId = req.ProducerId
+		req.Record.Sequence = req.Sequence
+	}
+	// Only the coordinator writes transactional records and markers.
+	req.Record.TransactionId = 0
+	req.Record.Control = api.Control_CONTROL_NONE
+	if err := s.validate(req.Topic, req.Record); err != nil {
+		return nil, err
+	}
+	inject(ctx, req.Record)
+	var offset uint64
+	var err error
+	if req.TransactionId != 0 {
+		if s.transactions == nil {
+			return nil, errTransactionsUnavailable
+		}
+		offset, err = s.transactions.produce(req.TransactionId, req.Topic, req.Record)
+	} else {
+		var log CommitLog
+		if log, err = s.topic(req.Topic); err == nil {
+			offset, err = log.Append(req.Record)
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -49,8 +448,17 @@ func (s *grpcServer) Produce(ctx context.Context, req *api.ProduceRequest) (*api
 	}, nil
 }
 
-func (s *grpcServer) Consume(ctx context.Context, req *api.ConsumeRequest) (*api.ConsumeResponse, error) {
-	record, err := s.CommitLog.Read(req.Offset)
+func (s *grpcServer) consume(req *api.ConsumeRequest) (*api.ConsumeResponse, error) {
+	log, err := s.topic(req.Topic)
+	if err != nil {
+		return nil, err
+	}
+	var record *api.Record
+	if req.ReadUncommitted {
+		record, err = log.Read(req.Offset)
+	} else {
+		record, err = log.ReadCommitted(req.Offset)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -59,40 +467,77 @@ func (s *grpcServer) Consume(ctx context.Context, req *api.ConsumeRequest) (*api
 	}, nil
 }
 
-func (s *grpcServer) ProduceStream(stream api.Log_ProduceStreamServer) error {
-	for {
-		req, err := stream.Recv()
-		if err != nil {
-			return err
-		}
-		res, err := s.Produce(stream.Context(), req)
-		if err != nil {
-			return err
+// validateProduce rejects records the server won't append: missing ones,
+// ones larger than the limit, and ones with an offset, which is the log's to
+// assign.
+func (c *Config) validateProduce(req *api.ProduceRequest) error {
+	if req.Record == nil {
+		return api.ErrInvalidRequest{Field: "record", Reason: "is required"}
+	}
+	if req.Record.Offset != 0 {
+		return api.ErrInvalidRequest{
+			Field:  "record.offset",
+			Reason: "is assigned by the log and must not be set",
 		}
-		if err = stream.Send(res); err != nil {
-			return err
+	}
+	if c.MaxRecordBytes != 0 {
+		if size := uint64(proto.Size(req.Record)); size > c.MaxRecordBytes {
+			return api.ErrRecordTooLarge{Size: size, Max: c.MaxRecordBytes}
 		}
 	}
+	return nil
 }
 
-func (s *grpcServer) ConsumeStream(req *api.ConsumeRequest, stream api.Log_ConsumeStreamServer) error {
-	for {
-		select {
-		case <-stream.Context().Done():
-			return nil
-		default:
-			res, err := s.Consume(stream.Context(), req)
-			switch err.(type) {
-			case nil:
-			case api.ErrOffsetOutOfRange:
-				continue
-			default:
-				return err
-			}
-			if err = stream.Send(res); err != nil {
-				return err
-			}
-			req.Offset++
-		}
+type subjectContextKey struct{}
+
+// subject returns the common name of the client certificate that was stored
+// in the context by authenticate.
+func subject(ctx context.Context) string {
+	subject, _ := ctx.Value(subjectContextKey{}).(string)
+	return subject
+}
+
+// authenticate reads the subject out of the client's verified certificate.
+// Connections without TLS get an empty subject.
+func authenticate(ctx context.Context) (context.Context, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ctx, status.New(codes.Unknown, "couldn't find peer info").Err()
+	}
+	if _, ok := p.AuthInfo.(gatewayAuthInfo); ok {
+		return authenticateGateway(ctx, p), nil
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 {
+		return context.WithValue(ctx, subjectContextKey{}, ""), nil
+	}
+	subject := tlsInfo.State.VerifiedChains[0][0].Subject.CommonName
+	return context.WithValue(ctx, subjectContextKey{}, subject), nil
+}
+
+func authenticateUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := authenticate(ctx)
+	if err != nil {
+		return nil, err
 	}
+	return handler(ctx, req)
+}
+
+func authenticateStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &wrappedStream{ServerStream: ss, ctx: ctx})
+}
+
+// wrappedStream overrides the context of a grpc.ServerStream so interceptors
+// can pass values down to stream handlers.
+type wrappedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (w *wrappedStream) Context() context.Context {
+	return w.ctx
 }
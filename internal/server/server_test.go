@@ -2,10 +2,13 @@ package server
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
@@ -13,12 +16,17 @@ import (
 	"github.com/srikantrao/proglog/internal/config"
 	"google.golang.org/grpc/credentials"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	api "github.com/srikantrao/proglog/api/v1"
 	"github.com/srikantrao/proglog/internal/auth"
 	"github.com/srikantrao/proglog/internal/log"
+	"github.com/srikantrao/proglog/internal/schema"
 )
 
 func TestServer(t *testing.T) {
@@ -33,6 +41,14 @@ func TestServer(t *testing.T) {
 		"produce/consume stream succeeds":                    testProduceConsumeStream,
 		"consume past log boundary fails":                    testConsumePastBoundary,
 		"unauthorized fails":                                 testUnauthorized,
+		"record metadata round-trips":                        testRecordMetadata,
+		"consume stream filters records":                     testConsumeStreamFilter,
+		"consume range pages through records":                testConsumeRange,
+		"rpcs and streams are measured":                      testMetrics,
+		"invalid records are rejected":                       testProduceValidation,
+		"consume from start positions":                       testConsumeStartPosition,
+		"consume stream batches records":                     testConsumeStreamBatches,
+		"consume flow waits for credit":                      testConsumeFlow,
 	} {
 		t.Run(scenario, func(t *testing.T) {
 			rootClient,
@@ -99,7 +115,6 @@ func setupTest(t *testing.T, fn func(*Config)) (
 
 	dir, err := ioutil.TempDir("", "server-test")
 	require.NoError(t, err)
-	defer os.RemoveAll(dir)
 
 	clog, err := log.NewLog(dir, log.Config{})
 	require.NoError(t, err)
@@ -125,6 +140,7 @@ func setupTest(t *testing.T, fn func(*Config)) (
 		rootConn.Close()
 		nobodyConn.Close()
 		l.Close()
+		os.RemoveAll(dir)
 	}
 }
 
@@ -176,6 +192,14 @@ func testConsumePastBoundary(
 	if got != want {
 		t.Fatalf("got err: %v, want: %v", got, want)
 	}
+	require.Equal(t, api.ErrOffsetOutOfRange{
+		Offset:        produce.Offset + 1,
+		LowWatermark:  0,
+		HighWatermark: produce.Offset + 1,
+	}, api.DecodeError(err))
+
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Topic: "missing"})
+	require.Equal(t, api.ErrTopicNotFound{Topic: "missing"}, api.DecodeError(err))
 }
 
 func testProduceConsumeStream(
@@ -185,12 +209,11 @@ func testProduceConsumeStream(
 ) {
 	ctx := context.Background()
 
+	// The log assigns the offsets.
 	records := []*api.Record{{
-		Value:  []byte("first message"),
-		Offset: 0,
+		Value: []byte("first message"),
 	}, {
-		Value:  []byte("second message"),
-		Offset: 1,
+		Value: []byte("second message"),
 	}}
 
 	{
@@ -264,6 +287,82 @@ func testUnauthorized(
 	if gotCode != wantCode {
 		t.Fatalf("got code: %d, want: %d", gotCode, wantCode)
 	}
+	_, err = client.RegisterSchema(ctx, &api.RegisterSchemaRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func testRecordMetadata(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	want := &api.Record{
+		Value:       []byte(`{"hello":"world"}`),
+		Key:         []byte("user-1"),
+		Headers:     map[string]string{"trace-id": "abc"},
+		Timestamp:   timestamppb.New(time.Unix(1600000000, 0)),
+		ContentType: "application/json",
+	}
+	produce, err := client.Produce(ctx, &api.ProduceRequest{Record: want})
+	require.NoError(t, err)
+	want.Offset = produce.Offset
+
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: produce.Offset})
+	require.NoError(t, err)
+	require.True(t, proto.Equal(want, consume.Record))
+}
+
+func testConsumeRange(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte(fmt.Sprintf("record %d", i))},
+		})
+		require.NoError(t, err)
+	}
+	offsets := func(res *api.ConsumeRangeResponse) []uint64 {
+		var offs []uint64
+		for _, record := range res.Records {
+			offs = append(offs, record.Offset)
+		}
+		return offs
+	}
+
+	// The last two records.
+	res, err := client.ConsumeRange(ctx, &api.ConsumeRangeRequest{
+		Reverse:    true,
+		MaxRecords: 2,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{4, 3}, offsets(res))
+	require.True(t, res.More)
+	require.Equal(t, uint64(3), res.NextOffset)
+
+	// Page forwards with a budget of two records' worth of bytes.
+	size := uint64(proto.Size(res.Records[0]))
+	var got []uint64
+	req := &api.ConsumeRangeRequest{MaxBytes: 2 * size}
+	for {
+		res, err = client.ConsumeRange(ctx, req)
+		require.NoError(t, err)
+		require.True(t, len(res.Records) <= 2)
+		got = append(got, offsets(res)...)
+		if !res.More {
+			break
+		}
+		req.From = res.NextOffset
+	}
+	require.Equal(t, []uint64{0, 1, 2, 3, 4}, got)
+
+	// Records bigger than the budget are still returned one at a time.
+	res, err = client.ConsumeRange(ctx, &api.ConsumeRangeRequest{
+		From:     1,
+		To:       3,
+		MaxBytes: 1,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1}, offsets(res))
+	require.True(t, res.More)
+	require.Equal(t, uint64(2), res.NextOffset)
 }
 
 func setupTest1(t *testing.T, fn func(*Config)) (
@@ -388,3 +487,265 @@ func setupTest2(t *testing.T, fn func(*Config)) (
 		l.Close()
 	}
 }
+
+func TestSchemaValidation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "schema-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		l, err := log.NewLog(dir, log.Config{})
+		require.NoError(t, err)
+		c.Schemas, err = schema.NewRegistry(l, schema.Config{})
+		require.NoError(t, err)
+	})
+	defer teardown()
+	ctx := context.Background()
+
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("anything")},
+	})
+	require.NoError(t, err)
+
+	res, err := client.RegisterSchema(ctx, &api.RegisterSchemaRequest{
+		Schema: &api.Schema{
+			Subject:    "default",
+			Type:       api.SchemaType_SCHEMA_TYPE_JSON,
+			Definition: []byte(`{"type":"object","required":["id"]}`),
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), res.Version)
+
+	got, err := client.GetSchema(ctx, &api.GetSchemaRequest{Subject: "default"})
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), got.Schema.Version)
+	require.Equal(t, api.Compatibility_COMPATIBILITY_BACKWARD, got.Compatibility)
+
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("anything")},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte(`{"id":1}`)},
+	})
+	require.NoError(t, err)
+}
+
+func testProduceValidation(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+	config.MaxRecordBytes = 16
+	for name, test := range map[string]struct {
+		record *api.Record
+		field  string
+	}{
+		"nil record":       {nil, "record"},
+		"offset set":       {&api.Record{Value: []byte("hello"), Offset: 3}, "record.offset"},
+		"record too large": {&api.Record{Value: []byte("hello world, hello world")}, "record"},
+	} {
+		_, err := client.Produce(ctx, &api.ProduceRequest{Record: test.record})
+		st := status.Convert(err)
+		require.Equal(t, codes.InvalidArgument, st.Code(), name)
+		var violations []*errdetails.BadRequest_FieldViolation
+		for _, d := range st.Details() {
+			if br, ok := d.(*errdetails.BadRequest); ok {
+				violations = br.FieldViolations
+			}
+		}
+		require.Len(t, violations, 1, name)
+		require.Equal(t, test.field, violations[0].Field, name)
+	}
+
+	_, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello")},
+	})
+	require.NoError(t, err)
+}
+
+func testConsumeStartPosition(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+	start := time.Unix(1600000000, 0)
+	produce := func(n int) {
+		for i := 0; i < n; i++ {
+			_, err := client.Produce(ctx, &api.ProduceRequest{
+				Record: &api.Record{
+					Value:     []byte("hello world"),
+					Timestamp: timestamppb.New(start.Add(time.Duration(i) * time.Minute)),
+				},
+			})
+			require.NoError(t, err)
+		}
+	}
+	// Offsets 0 to 2 are truncated away, leaving 3 to 5.
+	produce(3)
+	require.NoError(t, config.CommitLog.Roll())
+	produce(3)
+	require.NoError(t, config.CommitLog.Truncate(4))
+
+	for name, test := range map[string]struct {
+		req    *api.ConsumeRequest
+		offset uint64
+	}{
+		"offset": {&api.ConsumeRequest{Offset: 4}, 4},
+		"earliest": {&api.ConsumeRequest{
+			StartPosition: api.StartPosition_START_POSITION_EARLIEST,
+		}, 3},
+		"timestamp": {&api.ConsumeRequest{
+			StartPosition:  api.StartPosition_START_POSITION_TIMESTAMP,
+			StartTimestamp: timestamppb.New(start.Add(30 * time.Second)),
+		}, 4},
+		"from end": {&api.ConsumeRequest{
+			StartPosition: api.StartPosition_START_POSITION_FROM_END,
+			FromEnd:       2,
+		}, 4},
+		"from end past the start": {&api.ConsumeRequest{
+			StartPosition: api.StartPosition_START_POSITION_FROM_END,
+			FromEnd:       10,
+		}, 3},
+		"truncated offset reset to earliest": {&api.ConsumeRequest{
+			Offset:           1,
+			OutOfRangePolicy: api.OutOfRangePolicy_OUT_OF_RANGE_POLICY_EARLIEST,
+		}, 3},
+	} {
+		res, err := client.Consume(ctx, test.req)
+		require.NoError(t, err, name)
+		require.Equal(t, test.offset, res.Record.Offset, name)
+	}
+
+	_, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 1})
+	require.Equal(t, api.ErrOffsetOutOfRange{
+		Offset:        1,
+		LowWatermark:  3,
+		HighWatermark: 6,
+	}, api.DecodeError(err))
+
+	_, err = client.Consume(ctx, &api.ConsumeRequest{
+		StartPosition: api.StartPosition_START_POSITION_TIMESTAMP,
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Streams from the end only see records appended after they start.
+	for _, req := range []*api.ConsumeRequest{
+		{StartPosition: api.StartPosition_START_POSITION_LATEST},
+		{Offset: 100, OutOfRangePolicy: api.OutOfRangePolicy_OUT_OF_RANGE_POLICY_LATEST},
+	} {
+		stream, err := client.ConsumeStream(ctx, req)
+		require.NoError(t, err)
+		// Give the server time to resolve the start position before
+		// producing.
+		time.Sleep(50 * time.Millisecond)
+		produce(1)
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		high, err := config.CommitLog.HighestOffset()
+		require.NoError(t, err)
+		require.Equal(t, high, res.Record.Offset)
+	}
+}
+
+func testConsumeStreamBatches(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 5; i++ {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+	}
+	offsets := func(res *api.ConsumeResponse) []uint64 {
+		require.Nil(t, res.Record)
+		var offsets []uint64
+		for _, record := range res.Records {
+			offsets = append(offsets, record.Offset)
+		}
+		return offsets
+	}
+
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{MaxBatchRecords: 2})
+	require.NoError(t, err)
+	for _, want := range [][]uint64{{0, 1}, {2, 3}, {4}} {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, want, offsets(res))
+	}
+
+	// A batch short of min_bytes waits for more records, up to max_wait.
+	size := uint64(proto.Size(&api.Record{Value: []byte("hello world"), Offset: 5}))
+	stream, err = client.ConsumeStream(ctx, &api.ConsumeRequest{
+		Offset:   5,
+		MinBytes: 2 * size,
+		MaxWait:  durationpb.New(time.Hour),
+	})
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		time.Sleep(50 * time.Millisecond)
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+	}
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, []uint64{5, 6}, offsets(res))
+
+	stream, err = client.ConsumeStream(ctx, &api.ConsumeRequest{
+		Offset:   6,
+		MinBytes: 1 << 20,
+		MaxWait:  durationpb.New(50 * time.Millisecond),
+	})
+	require.NoError(t, err)
+	res, err = stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, []uint64{6}, offsets(res))
+}
+
+func testConsumeFlow(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 5; i++ {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+	}
+
+	stream, err := client.ConsumeFlow(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&api.ConsumeFlowRequest{
+		Consume: &api.ConsumeRequest{MaxBatchRecords: 10},
+		Credit:  2,
+	}))
+	recv := func() []uint64 {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		var offsets []uint64
+		for _, record := range res.Records {
+			offsets = append(offsets, record.Offset)
+		}
+		return offsets
+	}
+	require.Equal(t, []uint64{0, 1}, recv())
+
+	// Nothing more is sent until the client grants more credit.
+	received := make(chan []uint64)
+	go func() { received <- recv() }()
+	select {
+	case offsets := <-received:
+		t.Fatalf("got %v without credit", offsets)
+	case <-time.After(100 * time.Millisecond):
+	}
+	require.NoError(t, stream.Send(&api.ConsumeFlowRequest{Credit: 3}))
+	require.Equal(t, []uint64{2, 3, 4}, <-received)
+
+	// The stream ends once the client stops granting credit and has used
+	// it up.
+	require.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+
+	stream, err = client.ConsumeFlow(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&api.ConsumeFlowRequest{Credit: 1}))
+	_, err = stream.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
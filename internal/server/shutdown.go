@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errShuttingDown ends streams, and rejects RPCs that arrive, once the server
+// has started shutting down. Unavailable tells clients to retry, which they
+// should do against another server.
+var errShuttingDown = status.Error(codes.Unavailable, "server is shutting down, reconnect to another server")
+
+// The health service keeps answering during a shutdown so that it can report
+// it.
+const healthServicePrefix = "/grpc.health.v1.Health/"
+
+func (s *grpcServer) shutdownUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if s.shuttingDown() && !strings.HasPrefix(info.FullMethod, healthServicePrefix) {
+		return nil, errShuttingDown
+	}
+	return handler(ctx, req)
+}
+
+func (s *grpcServer) shutdownStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if s.shuttingDown() && !strings.HasPrefix(info.FullMethod, healthServicePrefix) {
+		return errShuttingDown
+	}
+	return handler(srv, ss)
+}
+
+func (c *Config) shuttingDown() bool {
+	select {
+	case <-c.Shutdown:
+		return true
+	default:
+		return false
+	}
+}
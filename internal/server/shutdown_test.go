@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	api "github.com/srikantrao/proglog/api/v1"
+)
+
+func TestShutdown(t *testing.T) {
+	shutdown := make(chan struct{})
+	client, _, _, teardown := setupTest(t, func(config *Config) {
+		config.Shutdown = shutdown
+	})
+	defer teardown()
+	ctx := context.Background()
+
+	produce, err := client.ProduceStream(ctx)
+	require.NoError(t, err)
+	require.NoError(t, produce.Send(&api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	}))
+	_, err = produce.Recv()
+	require.NoError(t, err)
+
+	consume, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	_, err = consume.Recv()
+	require.NoError(t, err)
+
+	close(shutdown)
+
+	// Streams end, telling their clients to go elsewhere...
+	_, err = consume.Recv()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	_, err = produce.Recv()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	// ...and new RPCs are turned away.
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	api "github.com/srikantrao/proglog/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// tailPollInterval is how long a tail waits before checking the log
+	// again once it has caught up.
+	tailPollInterval  = 100 * time.Millisecond
+	tailKeepAlive     = 15 * time.Second
+	lastEventIDHeader = "Last-Event-ID"
+)
+
+var upgrader = websocket.Upgrader{}
+
+// handleTail streams records to the client starting from the offset query
+// parameter, as WebSocket messages when the client asks for an upgrade and as
+// Server-Sent Events otherwise. Each event's id is the record's offset so a
+// client resuming with Last-Event-ID continues after the last record it saw.
+func (s *httpServer) handleTail(w http.ResponseWriter, r *http.Request) {
+	offset, err := tailOffset(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx := s.authContext(r, "/log.v1.Log/ConsumeStream")
+	if err := s.Authorizer.Enforce(ctx, httpSubject(r), objectWildcard, consumeAction); err != nil {
+		writeError(w, err)
+		return
+	}
+	// Offsets truncated away are reported before the stream starts. A tail
+	// the log is truncated past later ends with an error event.
+	if low, high := watermarks(s.CommitLog); offset < low {
+		writeError(w, api.ErrOffsetOutOfRange{
+			Offset:        offset,
+			LowWatermark:  low,
+			HighWatermark: high,
+		})
+		return
+	}
+	if websocket.IsWebSocketUpgrade(r) {
+		s.tailWebSocket(w, r, offset)
+		return
+	}
+	s.tailEventStream(w, r, offset)
+}
+
+func (s *httpServer) tailEventStream(w http.ResponseWriter, r *http.Request, offset uint64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(tailKeepAlive)
+	defer keepAlive.Stop()
+	err := s.tail(r.Context(), httpSubject(r), offset, func(record *api.Record) error {
+		if record == nil {
+			select {
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return err
+				}
+				flusher.Flush()
+			default:
+			}
+			return nil
+		}
+		data, err := json.Marshal(newRecord(record))
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", record.Offset, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+	if _, ok := err.(api.ErrOffsetOutOfRange); ok {
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", err)
+		flusher.Flush()
+	}
+}
+
+func (s *httpServer) tailWebSocket(w http.ResponseWriter, r *http.Request, offset uint64) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied to the client.
+		return
+	}
+	defer conn.Close()
+
+	// The client doesn't send us anything, but reading is how we find out
+	// that it went away.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	err = s.tail(ctx, httpSubject(r), offset, func(record *api.Record) error {
+		if record == nil {
+			return nil
+		}
+		return conn.WriteJSON(newRecord(record))
+	})
+	switch err.(type) {
+	case api.ErrOffsetOutOfRange:
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "offset truncated from the log"),
+			time.Now().Add(time.Second))
+	default:
+		if err == errShuttingDown {
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseServiceRestart, "server is shutting down"),
+				time.Now().Add(time.Second))
+		}
+	}
+}
+
+// tail calls send with every record from offset onwards until ctx is done,
+// send fails, the log is truncated past the tail, in which case it returns
+// ErrOffsetOutOfRange, or the server shuts down, in which case it returns
+// errShuttingDown. Records are sent no faster than the subject's consume
+// quota allows. Once it has caught up with the log it calls send with a nil
+// record between polls so that callers can do housekeeping.
+func (s *httpServer) tail(ctx context.Context, subject string, offset uint64, send func(*api.Record) error) error {
+	for {
+		if s.shuttingDown() {
+			return errShuttingDown
+		}
+		record, err := s.CommitLog.ReadCommitted(offset)
+		switch err.(type) {
+		case nil:
+			if s.Quotas != nil {
+				n := proto.Size(&api.ConsumeResponse{Record: record})
+				if err := s.Quotas.Wait(ctx, subject, consumeAction, n); err != nil {
+					if ctx.Err() != nil {
+						return nil
+					}
+					return err
+				}
+			}
+			if err := send(record); err != nil {
+				return err
+			}
+			offset = record.Offset + 1
+			continue
+		case api.ErrOffsetOutOfRange:
+			if e := err.(api.ErrOffsetOutOfRange); e.Offset < e.LowWatermark {
+				// The log was truncated past the tail.
+				return err
+			}
+		default:
+			return err
+		}
+		if err := send(nil); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.Shutdown:
+			return errShuttingDown
+		case <-time.After(tailPollInterval):
+		}
+	}
+}
+
+// tailOffset returns the offset to start tailing from. A Last-Event-ID,
+// sent as a header by reconnecting EventSources or as a query parameter by
+// other clients, takes precedence over the offset parameter.
+func tailOffset(r *http.Request) (uint64, error) {
+	lastEventID := r.Header.Get(lastEventIDHeader)
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	if lastEventID != "" {
+		off, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %q", lastEventIDHeader, lastEventID)
+		}
+		return off + 1, nil
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		off, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid offset: %q", v)
+		}
+		return off, nil
+	}
+	return 0, nil
+}
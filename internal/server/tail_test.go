@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	api "github.com/srikantrao/proglog/api/v1"
+	"github.com/srikantrao/proglog/internal/auth"
+	"github.com/srikantrao/proglog/internal/config"
+	"github.com/srikantrao/proglog/internal/log"
+)
+
+func TestTail(t *testing.T) {
+	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.ServerCertFile,
+		KeyFile:  config.ServerKeyFile,
+		CAFile:   config.CAFile,
+		Server:   true,
+	})
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "tail-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := clog.Append(&api.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+		require.NoError(t, err)
+	}
+	cfg := &Config{
+		CommitLog:  clog,
+		Authorizer: auth.New(config.ACLModelFile, config.ACLPolicyFile),
+	}
+
+	srv := NewHTTPServer("", cfg, serverTLSConfig)
+	ts := httptest.NewUnstartedServer(srv.Handler)
+	ts.TLS = srv.TLSConfig
+	ts.StartTLS()
+	defer ts.Close()
+
+	clientTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.RootClientCertFile,
+		KeyFile:  config.RootClientKeyFile,
+		CAFile:   config.CAFile,
+	})
+	require.NoError(t, err)
+
+	t.Run("server-sent events resume after Last-Event-ID", func(t *testing.T) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLSConfig}}
+		req, err := http.NewRequest("GET", ts.URL+"/tail", nil)
+		require.NoError(t, err)
+		req.Header.Set(lastEventIDHeader, "0")
+		res, err := client.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+		r := bufio.NewReader(res.Body)
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, "id: 1\n", line)
+		line, err = r.ReadString('\n')
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(line, "data: "))
+		require.Contains(t, line, `"offset":1`)
+	})
+
+	t.Run("websocket tails new records", func(t *testing.T) {
+		dialer := websocket.Dialer{TLSClientConfig: clientTLSConfig}
+		url := strings.Replace(ts.URL, "https://", "wss://", 1) + "/tail?offset=2"
+		conn, _, err := dialer.Dial(url, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		var got Record
+		require.NoError(t, conn.ReadJSON(&got))
+		require.Equal(t, uint64(2), got.Offset)
+
+		_, err = clog.Append(&api.Record{Value: []byte("record 3")})
+		require.NoError(t, err)
+		require.NoError(t, conn.ReadJSON(&got))
+		require.Equal(t, uint64(3), got.Offset)
+		require.Equal(t, []byte("record 3"), got.Value)
+	})
+
+	t.Run("truncated offsets fail", func(t *testing.T) {
+		require.NoError(t, clog.Roll())
+		_, err = clog.Append(&api.Record{Value: []byte("record 4")})
+		require.NoError(t, err)
+		require.NoError(t, clog.Truncate(5))
+
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLSConfig}}
+		res, err := client.Get(ts.URL + "/tail?offset=0")
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+		// Tails that fall behind the truncation end rather than wait.
+		hs := &httpServer{Config: cfg}
+		err = hs.tail(context.Background(), "", 1, func(*api.Record) error { return nil })
+		require.Equal(t, api.ErrOffsetOutOfRange{
+			Offset:        1,
+			LowWatermark:  4,
+			HighWatermark: 5,
+		}, err)
+	})
+
+	t.Run("tails are held to the consume quota", func(t *testing.T) {
+		for i := 5; i < 7; i++ {
+			_, err := clog.Append(&api.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+			require.NoError(t, err)
+		}
+		quotaCfg := *cfg
+		quotaCfg.Quotas = auth.NewQuotas(map[string]map[string]auth.Quota{
+			"root": {consumeAction: {RequestsPerSecond: 2}},
+		})
+		hs := &httpServer{Config: &quotaCfg}
+		done := errors.New("done")
+		start := time.Now()
+		err := hs.tail(context.Background(), "root", 4, func(record *api.Record) error {
+			if record != nil && record.Offset == 6 {
+				return done
+			}
+			return nil
+		})
+		require.Equal(t, done, err)
+		// The bucket holds two requests, so the third record waits.
+		require.True(t, time.Since(start) >= 400*time.Millisecond)
+	})
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+
+	api "github.com/srikantrao/proglog/api/v1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const instrumentationName = "github.com/srikantrao/proglog/internal/server"
+
+// propagator carries trace contexts in gRPC metadata and record headers in
+// the W3C Trace Context format, i.e. the traceparent and tracestate keys.
+var propagator = propagation.TraceContext{}
+
+// NewTracerProvider returns a tracer provider that exports spans over OTLP
+// to the gRPC collector at endpoint. Shut it down to flush the spans it
+// hasn't exported yet.
+func NewTracerProvider(ctx context.Context, endpoint string) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracegrpc.New(ctx,
+		otlptracegrpc.WithEndpoint(endpoint),
+		otlptracegrpc.WithInsecure(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String("proglog"),
+		)),
+	), nil
+}
+
+type tracing struct {
+	tracer trace.Tracer
+}
+
+func newTracing(tp trace.TracerProvider) *tracing {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return &tracing{tracer: tp.Tracer(instrumentationName)}
+}
+
+// start starts a server span for the RPC, continuing the caller's trace if
+// its metadata carries one.
+func (t *tracing) start(ctx context.Context, method string) (context.Context, trace.Span) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = propagator.Extract(ctx, metadataCarrier(md))
+	return t.tracer.Start(ctx, method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.RPCSystemKey.String("grpc"),
+			semconv.RPCMethodKey.String(method),
+		),
+	)
+}
+
+func (t *tracing) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, span := t.start(ctx, info.FullMethod)
+	defer span.End()
+	res, err := handler(ctx, req)
+	setSpanStatus(span, err)
+	return res, err
+}
+
+func (t *tracing) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, span := t.start(ss.Context(), info.FullMethod)
+	defer span.End()
+	err := handler(srv, &wrappedStream{ServerStream: ss, ctx: ctx})
+	setSpanStatus(span, err)
+	return err
+}
+
+func setSpanStatus(span trace.Span, err error) {
+	code := status.Code(err)
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(code)))
+	if err != nil {
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+}
+
+// inject stores the trace context of ctx in the record's headers so that
+// consumers can link their spans to the one that produced the record. A
+// trace context set by the producing client is kept.
+func inject(ctx context.Context, record *api.Record) {
+	if _, ok := record.Headers["traceparent"]; ok {
+		return
+	}
+	carrier := headerCarrier{}
+	propagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return
+	}
+	if record.Headers == nil {
+		record.Headers = make(map[string]string, len(carrier))
+	}
+	for k, v := range carrier {
+		record.Headers[k] = v
+	}
+}
+
+// consumed records the delivery of records to a consumer as a span linked to
+// the spans that produced them.
+func (t *tracing) consumed(ctx context.Context, topic string, records ...*api.Record) {
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return
+	}
+	var links []trace.Link
+	for _, record := range records {
+		sc := trace.SpanContextFromContext(
+			propagator.Extract(context.Background(), headerCarrier(record.Headers)),
+		)
+		if sc.IsValid() {
+			links = append(links, trace.Link{
+				SpanContext: sc,
+				Attributes:  []attribute.KeyValue{attribute.Int64("proglog.offset", int64(record.Offset))},
+			})
+		}
+	}
+	_, span := t.tracer.Start(ctx, "consume",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			attribute.String("proglog.topic", topic),
+			attribute.Int("proglog.records", len(records)),
+		),
+	)
+	span.End()
+}
+
+// metadataCarrier adapts gRPC metadata to a propagation.TextMapCarrier.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// headerCarrier adapts record headers to a propagation.TextMapCarrier.
+type headerCarrier map[string]string
+
+func (c headerCarrier) Get(key string) string {
+	return c[key]
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
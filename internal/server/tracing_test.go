@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+
+	api "github.com/srikantrao/proglog/api/v1"
+)
+
+// collector stands in for an OpenTelemetry collector and keeps the spans it
+// receives.
+type collector struct {
+	collectortrace.UnimplementedTraceServiceServer
+	mu    sync.Mutex
+	spans []*tracepb.Span
+}
+
+func (c *collector) Export(ctx context.Context, req *collectortrace.ExportTraceServiceRequest) (*collectortrace.ExportTraceServiceResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, ils := range rs.InstrumentationLibrarySpans {
+			c.spans = append(c.spans, ils.Spans...)
+		}
+	}
+	return &collectortrace.ExportTraceServiceResponse{}, nil
+}
+
+func (c *collector) span(name string) *tracepb.Span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, span := range c.spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func TestTracing(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	c := &collector{}
+	gsrv := grpc.NewServer()
+	collectortrace.RegisterTraceServiceServer(gsrv, c)
+	go gsrv.Serve(l)
+	defer gsrv.Stop()
+
+	ctx := context.Background()
+	tp, err := NewTracerProvider(ctx, l.Addr().String())
+	require.NoError(t, err)
+	defer tp.Shutdown(ctx)
+
+	client, _, _, teardown := setupTest(t, func(config *Config) {
+		config.TracerProvider = tp
+	})
+	defer teardown()
+
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+	res, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	require.Contains(t, res.Record.Headers, "traceparent")
+	require.NoError(t, tp.ForceFlush(ctx))
+
+	produce := c.span("/log.v1.Log/Produce")
+	require.NotNil(t, produce)
+	rpc := c.span("/log.v1.Log/Consume")
+	require.NotNil(t, rpc)
+	consume := c.span("consume")
+	require.NotNil(t, consume)
+
+	// The consume span is part of the Consume RPC's trace and links back to
+	// the RPC that produced the record.
+	require.Equal(t, rpc.TraceId, consume.TraceId)
+	require.Equal(t, rpc.SpanId, consume.ParentSpanId)
+	require.Len(t, consume.Links, 1)
+	require.True(t, bytes.Equal(produce.TraceId, consume.Links[0].TraceId))
+	require.True(t, bytes.Equal(produce.SpanId, consume.Links[0].SpanId))
+}
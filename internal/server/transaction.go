@@ -0,0 +1,307 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+	"time"
+
+	api "github.com/srikantrao/proglog/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const defaultTransactionTimeout = time.Minute
+
+// States of a transaction in the coordinator's log. A transaction is
+// complete once the markers for its outcome are in every log it wrote to.
+const (
+	transactionOngoing       = "ongoing"
+	transactionPrepareCommit = "prepare_commit"
+	transactionPrepareAbort  = "prepare_abort"
+	transactionComplete      = "complete"
+)
+
+// transactionEntry is a record in the coordinator's log.
+type transactionEntry struct {
+	ID     uint64   `json:"id"`
+	State  string   `json:"state"`
+	Topics []string `json:"topics"`
+}
+
+type transaction struct {
+	state  string
+	topics []string
+	timer  *time.Timer
+	// appends counts the records being appended outside the coordinator's
+	// lock, which have to land before the markers.
+	appends sync.WaitGroup
+}
+
+// coordinator runs transactions across the server's logs. Before writing the
+// commit or abort markers it records its decision in its own log, so that a
+// server that crashes half way through finishes the transaction when it
+// restarts. Transactions that are still open at startup, or that stay open
+// longer than the timeout, are aborted.
+type coordinator struct {
+	mu      sync.Mutex
+	log     CommitLog
+	topic   func(name string) (CommitLog, error)
+	timeout time.Duration
+	txns    map[uint64]*transaction
+	stopped bool
+}
+
+func newCoordinator(
+	log CommitLog,
+	topic func(string) (CommitLog, error),
+	timeout time.Duration,
+) (*coordinator, error) {
+	if timeout == 0 {
+		timeout = defaultTransactionTimeout
+	}
+	c := &coordinator{
+		log:     log,
+		topic:   topic,
+		timeout: timeout,
+		txns:    make(map[uint64]*transaction),
+	}
+	return c, c.recover()
+}
+
+// recover replays the coordinator's log and finishes every transaction that
+// wasn't complete when the server stopped.
+func (c *coordinator) recover() error {
+	lowest, err := c.log.LowestOffset()
+	if err != nil {
+		return err
+	}
+	latest := make(map[uint64]transactionEntry)
+	var order []uint64
+	for off := lowest; ; off++ {
+		record, err := c.log.Read(off)
+		if _, ok := err.(api.ErrOffsetOutOfRange); ok {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var entry transactionEntry
+		if err := json.Unmarshal(record.Value, &entry); err != nil {
+			return err
+		}
+		if _, ok := latest[entry.ID]; !ok {
+			order = append(order, entry.ID)
+		}
+		latest[entry.ID] = entry
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range order {
+		entry := latest[id]
+		if entry.State == transactionComplete {
+			continue
+		}
+		txn := &transaction{state: entry.State, topics: entry.Topics}
+		c.txns[id] = txn
+		if err := c.end(id, txn, entry.State == transactionPrepareCommit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stop cancels the timeouts of the open transactions, which are aborted when
+// the server restarts instead.
+func (c *coordinator) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopped = true
+	for _, txn := range c.txns {
+		if txn.timer != nil {
+			txn.timer.Stop()
+		}
+	}
+}
+
+func (c *coordinator) begin() (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := newTransactionID()
+	if err := c.write(id, transactionOngoing, nil); err != nil {
+		return 0, err
+	}
+	c.txns[id] = &transaction{
+		state: transactionOngoing,
+		timer: time.AfterFunc(c.timeout, func() {
+			c.expire(id)
+		}),
+	}
+	return id, nil
+}
+
+// produce appends the record to the topic as part of the transaction. The
+// coordinator records every topic the transaction writes to before the first
+// write so that it knows where to put the markers. The append itself runs
+// outside the coordinator's lock so that transactions don't wait on each
+// other's writes.
+func (c *coordinator) produce(id uint64, topic string, record *api.Record) (uint64, error) {
+	txn, log, err := c.startAppend(id, topic)
+	if err != nil {
+		return 0, err
+	}
+	defer txn.appends.Done()
+	record.TransactionId = id
+	return log.Append(record)
+}
+
+// startAppend records the topic in the transaction and counts the append
+// about to be made to it.
+func (c *coordinator) startAppend(id uint64, topic string) (*transaction, CommitLog, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	txn, err := c.get(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if txn.state != transactionOngoing {
+		return nil, nil, status.Errorf(codes.FailedPrecondition, "transaction %d is finishing", id)
+	}
+	log, err := c.topic(topic)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !contains(txn.topics, topic) {
+		topics := append(txn.topics, topic)
+		if err := c.write(id, transactionOngoing, topics); err != nil {
+			return nil, nil, err
+		}
+		txn.topics = topics
+	}
+	txn.appends.Add(1)
+	return txn, log, nil
+}
+
+func (c *coordinator) commit(id uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	txn, err := c.get(id)
+	if err != nil {
+		return err
+	}
+	if txn.state == transactionPrepareAbort {
+		return status.Errorf(codes.FailedPrecondition, "transaction %d is being aborted", id)
+	}
+	return c.end(id, txn, true)
+}
+
+// expire aborts a transaction that timed out, unless the coordinator has
+// been stopped in the meantime.
+func (c *coordinator) expire(id uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return
+	}
+	txn, err := c.get(id)
+	if err != nil || txn.state == transactionPrepareCommit {
+		return
+	}
+	c.end(id, txn, false)
+}
+
+func (c *coordinator) abort(id uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	txn, err := c.get(id)
+	if err != nil {
+		return err
+	}
+	if txn.state == transactionPrepareCommit {
+		return status.Errorf(codes.FailedPrecondition, "transaction %d is being committed", id)
+	}
+	return c.end(id, txn, false)
+}
+
+// end records the outcome, writes the markers and completes the transaction.
+// If a marker can't be written the transaction stays prepared and ending it
+// again retries the markers.
+func (c *coordinator) end(id uint64, txn *transaction, commit bool) error {
+	state, control := transactionPrepareAbort, api.Control_CONTROL_ABORT
+	if commit {
+		state, control = transactionPrepareCommit, api.Control_CONTROL_COMMIT
+	}
+	if txn.state != state {
+		if err := c.write(id, state, txn.topics); err != nil {
+			return err
+		}
+		txn.state = state
+	}
+	if txn.timer != nil {
+		txn.timer.Stop()
+	}
+	// No appends start once the transaction is prepared; wait for those
+	// already running so that the markers come after them.
+	txn.appends.Wait()
+	for _, topic := range txn.topics {
+		log, err := c.topic(topic)
+		if err != nil {
+			return err
+		}
+		if _, err := log.Append(&api.Record{
+			TransactionId: id,
+			Control:       control,
+		}); err != nil {
+			return err
+		}
+	}
+	if err := c.write(id, transactionComplete, txn.topics); err != nil {
+		return err
+	}
+	delete(c.txns, id)
+	return nil
+}
+
+func (c *coordinator) get(id uint64) (*transaction, error) {
+	txn, ok := c.txns[id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "transaction %d not found", id)
+	}
+	return txn, nil
+}
+
+func (c *coordinator) write(id uint64, state string, topics []string) error {
+	b, err := json.Marshal(transactionEntry{
+		ID:     id,
+		State:  state,
+		Topics: topics,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.log.Append(&api.Record{Value: b})
+	return err
+}
+
+func contains(topics []string, topic string) bool {
+	for _, t := range topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// newTransactionID returns a random, non-zero transaction ID.
+func newTransactionID() uint64 {
+	var b [8]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			panic(err)
+		}
+		if id := binary.BigEndian.Uint64(b[:]); id != 0 {
+			return id
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	api "github.com/srikantrao/proglog/api/v1"
+	"github.com/srikantrao/proglog/internal/log"
+)
+
+func TestTransactions(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		t *testing.T,
+		client api.LogClient,
+		config *Config,
+	){
+		"records are hidden until commit":        testTransactionCommit,
+		"aborted records are never visible":      testTransactionAbort,
+		"transactions span topics":               testTransactionTopics,
+		"open transactions abort after recovery": testTransactionRecovery,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "transaction-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			client, _, config, teardown := setupTest(t, func(c *Config) {
+				txnLog, err := log.NewLog(dir, log.Config{})
+				require.NoError(t, err)
+				c.TransactionLog = txnLog
+			})
+			defer teardown()
+			fn(t, client, config)
+		})
+	}
+}
+
+func testTransactionCommit(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	txn, err := client.BeginTransaction(ctx, &api.BeginTransactionRequest{})
+	require.NoError(t, err)
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record:        &api.Record{Value: []byte("in transaction")},
+		TransactionId: txn.TransactionId,
+	})
+	require.NoError(t, err)
+	// Records after an open transaction are held back too so that consumers
+	// read in offset order.
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("after transaction")},
+	})
+	require.NoError(t, err)
+
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+
+	res, err := client.Consume(ctx, &api.ConsumeRequest{
+		Offset:          0,
+		ReadUncommitted: true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []byte("in transaction"), res.Record.Value)
+
+	_, err = client.CommitTransaction(ctx, &api.CommitTransactionRequest{
+		TransactionId: txn.TransactionId,
+	})
+	require.NoError(t, err)
+
+	res, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	require.Equal(t, []byte("in transaction"), res.Record.Value)
+	res, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 1})
+	require.NoError(t, err)
+	require.Equal(t, []byte("after transaction"), res.Record.Value)
+	// The commit marker is skipped.
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 2})
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+
+	res, err = client.Consume(ctx, &api.ConsumeRequest{
+		Offset:          2,
+		ReadUncommitted: true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, api.Control_CONTROL_COMMIT, res.Record.Control)
+
+	_, err = client.CommitTransaction(ctx, &api.CommitTransactionRequest{
+		TransactionId: txn.TransactionId,
+	})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func testTransactionAbort(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	txn, err := client.BeginTransaction(ctx, &api.BeginTransactionRequest{})
+	require.NoError(t, err)
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record:        &api.Record{Value: []byte("aborted")},
+		TransactionId: txn.TransactionId,
+	})
+	require.NoError(t, err)
+	_, err = client.AbortTransaction(ctx, &api.AbortTransactionRequest{
+		TransactionId: txn.TransactionId,
+	})
+	require.NoError(t, err)
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+
+	res, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello world"), res.Record.Value)
+	require.Equal(t, uint64(2), res.Record.Offset)
+
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record:        &api.Record{Value: []byte("too late")},
+		TransactionId: txn.TransactionId,
+	})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func testTransactionTopics(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "transaction-test-topic")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	events, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	config.Topics = map[string]CommitLog{"events": events}
+
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+		Topic:  "missing",
+	})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	txn, err := client.BeginTransaction(ctx, &api.BeginTransactionRequest{})
+	require.NoError(t, err)
+	for _, topic := range []string{"", "events"} {
+		_, err = client.Produce(ctx, &api.ProduceRequest{
+			Record:        &api.Record{Value: []byte("topic " + topic)},
+			Topic:         topic,
+			TransactionId: txn.TransactionId,
+		})
+		require.NoError(t, err)
+	}
+	_, err = client.CommitTransaction(ctx, &api.CommitTransactionRequest{
+		TransactionId: txn.TransactionId,
+	})
+	require.NoError(t, err)
+
+	for _, topic := range []string{"", "events"} {
+		res, err := client.Consume(ctx, &api.ConsumeRequest{Topic: topic})
+		require.NoError(t, err)
+		require.Equal(t, []byte("topic "+topic), res.Record.Value)
+	}
+}
+
+func testTransactionRecovery(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	// Recovery starts wherever the transaction log has been truncated to.
+	done, err := client.BeginTransaction(ctx, &api.BeginTransactionRequest{})
+	require.NoError(t, err)
+	_, err = client.AbortTransaction(ctx, &api.AbortTransactionRequest{
+		TransactionId: done.TransactionId,
+	})
+	require.NoError(t, err)
+	require.NoError(t, config.TransactionLog.Roll())
+	high, err := config.TransactionLog.HighestOffset()
+	require.NoError(t, err)
+	// Drop the segment holding the finished transaction.
+	require.NoError(t, config.TransactionLog.Truncate(high+2))
+	lowest, err := config.TransactionLog.LowestOffset()
+	require.NoError(t, err)
+	require.NotZero(t, lowest)
+
+	txn, err := client.BeginTransaction(ctx, &api.BeginTransactionRequest{})
+	require.NoError(t, err)
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record:        &api.Record{Value: []byte("in transaction")},
+		TransactionId: txn.TransactionId,
+	})
+	require.NoError(t, err)
+
+	// A new coordinator over the same logs is what a restarted server sees.
+	srv, err := newgrpcServer(config)
+	require.NoError(t, err)
+	require.NotNil(t, srv.transactions)
+
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+	res, err := client.Consume(ctx, &api.ConsumeRequest{
+		Offset:          1,
+		ReadUncommitted: true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, api.Control_CONTROL_ABORT, res.Record.Control)
+}
+
+// blockingLog holds appends until release is closed, signalling the first
+// on appending.
+type blockingLog struct {
+	CommitLog
+	appending chan struct{}
+	release   chan struct{}
+}
+
+func (l *blockingLog) Append(record *api.Record) (uint64, error) {
+	select {
+	case l.appending <- struct{}{}:
+	default:
+	}
+	<-l.release
+	return l.CommitLog.Append(record)
+}
+
+func TestCoordinatorAppendsConcurrently(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coordinator-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	newLog := func(name string) *log.Log {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, name), 0755))
+		l, err := log.NewLog(filepath.Join(dir, name), log.Config{})
+		require.NoError(t, err)
+		return l
+	}
+	txnLog, fast, slowLog := newLog("transactions"), newLog("fast"), newLog("slow")
+	slow := &blockingLog{
+		CommitLog: slowLog,
+		appending: make(chan struct{}, 1),
+		release:   make(chan struct{}),
+	}
+	c, err := newCoordinator(txnLog, func(name string) (CommitLog, error) {
+		if name == "slow" {
+			return slow, nil
+		}
+		return fast, nil
+	}, 0)
+	require.NoError(t, err)
+	defer c.stop()
+
+	blocked, err := c.begin()
+	require.NoError(t, err)
+	other, err := c.begin()
+	require.NoError(t, err)
+
+	produced := make(chan error, 1)
+	go func() {
+		_, err := c.produce(blocked, "slow", &api.Record{Value: []byte("slow")})
+		produced <- err
+	}()
+	<-slow.appending
+
+	// Another transaction appends while the slow append is held.
+	_, err = c.produce(other, "fast", &api.Record{Value: []byte("fast")})
+	require.NoError(t, err)
+	require.NoError(t, c.commit(other))
+
+	// Committing waits for the held append so its marker comes after it.
+	committed := make(chan error, 1)
+	go func() { committed <- c.commit(blocked) }()
+	select {
+	case <-committed:
+		t.Fatal("commit finished before the transaction's append")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(slow.release)
+	require.NoError(t, <-produced)
+	require.NoError(t, <-committed)
+
+	record, err := slowLog.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("slow"), record.Value)
+	marker, err := slowLog.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, api.Control_CONTROL_COMMIT, marker.Control)
+}
@@ -0,0 +1,26 @@
+// Package client provides a Producer and Consumer built on the generated
+// api.LogClient that take care of batching, retries and reconnects.
+package client
+
+import (
+	"io"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// retryable reports whether err is a transient failure that is worth retrying
+// against the same or another server.
+func retryable(err error) bool {
+	if err == io.EOF {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable,
+		codes.DeadlineExceeded,
+		codes.ResourceExhausted,
+		codes.Aborted:
+		return true
+	}
+	return false
+}
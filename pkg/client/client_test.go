@@ -0,0 +1,206 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	api "github.com/srikantrao/proglog/api/v1"
+	"github.com/srikantrao/proglog/internal/auth"
+	"github.com/srikantrao/proglog/internal/config"
+	"github.com/srikantrao/proglog/internal/log"
+	"github.com/srikantrao/proglog/internal/server"
+)
+
+func TestClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "client-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	cfg := &server.Config{
+		CommitLog:  clog,
+		Authorizer: auth.New(config.ACLModelFile, config.ACLPolicyFile),
+	}
+	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.ServerCertFile,
+		KeyFile:  config.ServerKeyFile,
+		CAFile:   config.CAFile,
+		Server:   true,
+	})
+	require.NoError(t, err)
+
+	// serve returns its errors rather than failing the test, as it's also
+	// called from other goroutines.
+	serve := func(addr string) (*grpc.Server, string, error) {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, "", err
+		}
+		srv, err := server.NewGRPCServer(cfg, grpc.Creds(credentials.NewTLS(serverTLSConfig)))
+		if err != nil {
+			l.Close()
+			return nil, "", err
+		}
+		go srv.Serve(l)
+		return srv, l.Addr().String(), nil
+	}
+	srv, addr, err := serve("127.0.0.1:0")
+	require.NoError(t, err)
+
+	clientTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.RootClientCertFile,
+		KeyFile:  config.RootClientKeyFile,
+		CAFile:   config.CAFile,
+	})
+	require.NoError(t, err)
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(credentials.NewTLS(clientTLSConfig)))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := api.NewLogClient(conn)
+
+	// Produce more records than fit in a batch.
+	producer := NewProducer(client, ProducerConfig{BatchSize: 4, Linger: time.Millisecond})
+	var results []<-chan ProduceResult
+	var records []*api.Record
+	for i := 0; i < 10; i++ {
+		record := &api.Record{Value: []byte(fmt.Sprintf("record %d", i))}
+		records = append(records, record)
+		results = append(results, producer.Produce(record))
+	}
+	producer.Flush()
+	for i, result := range results {
+		res := <-result
+		require.NoError(t, res.Err)
+		require.Equal(t, uint64(i), res.Offset)
+	}
+	// The producer stamps records it's given without a timestamp, on its own
+	// copy of them.
+	record, err := clog.Read(0)
+	require.NoError(t, err)
+	require.NotNil(t, record.Timestamp)
+	require.Nil(t, records[0].Timestamp)
+	require.NoError(t, producer.Close())
+	res := <-producer.Produce(&api.Record{Value: []byte("closed")})
+	require.Equal(t, ErrProducerClosed, res.Err)
+
+	// Consume half the records and stop.
+	offsets := &FileOffsetStore{Path: filepath.Join(dir, "offset")}
+	consume := func(until uint64) []uint64 {
+		var got []uint64
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		consumer := NewConsumer(client, ConsumerConfig{
+			Offsets:          offsets,
+			MaxBatchRecords:  3,
+			ReconnectBackoff: 10 * time.Millisecond,
+		})
+		err := consumer.Consume(ctx, func(record *api.Record) error {
+			got = append(got, record.Offset)
+			if record.Offset == until {
+				cancel()
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		return got
+	}
+	require.Equal(t, []uint64{0, 1, 2, 3, 4}, consume(4))
+
+	// A new consumer resumes from the committed offset and survives the
+	// server restarting underneath it.
+	restarted := make(chan error, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		srv.Stop()
+		var err error
+		if srv, _, err = serve(addr); err != nil {
+			restarted <- err
+			return
+		}
+		_, err = clog.Append(&api.Record{Value: []byte("after restart")})
+		restarted <- err
+	}()
+	require.Equal(t, []uint64{5, 6, 7, 8, 9, 10}, consume(10))
+	require.NoError(t, <-restarted)
+
+	// A consumer starting at the end of the log resolves that position
+	// once: records appended while it reconnects aren't skipped.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		srv.Stop()
+		if _, err := clog.Append(&api.Record{Value: []byte("while down")}); err != nil {
+			restarted <- err
+			return
+		}
+		var err error
+		if srv, _, err = serve(addr); err != nil {
+			restarted <- err
+			return
+		}
+		_, err = clog.Append(&api.Record{Value: []byte("after restart")})
+		restarted <- err
+	}()
+	var got []uint64
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	consumer := NewConsumer(client, ConsumerConfig{
+		StartPosition:    api.StartPosition_START_POSITION_LATEST,
+		ReconnectBackoff: 10 * time.Millisecond,
+	})
+	require.NoError(t, consumer.Consume(ctx, func(record *api.Record) error {
+		got = append(got, record.Offset)
+		if record.Offset == 12 {
+			cancel()
+		}
+		return nil
+	}))
+	require.NoError(t, <-restarted)
+	require.Equal(t, []uint64{11, 12}, got)
+
+	// Consumers can start at a time or a number of records from the end.
+	later := time.Now().Add(time.Hour)
+	_, err = clog.Append(&api.Record{
+		Value:     []byte("later"),
+		Timestamp: timestamppb.New(later),
+	})
+	require.NoError(t, err)
+	for scenario, tc := range map[string]struct {
+		config ConsumerConfig
+		want   []uint64
+	}{
+		"timestamp": {ConsumerConfig{
+			StartPosition:  api.StartPosition_START_POSITION_TIMESTAMP,
+			StartTimestamp: later,
+		}, []uint64{13}},
+		"from end": {ConsumerConfig{
+			StartPosition: api.StartPosition_START_POSITION_FROM_END,
+			FromEnd:       2,
+		}, []uint64{12, 13}},
+	} {
+		var got []uint64
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := NewConsumer(client, tc.config).Consume(ctx, func(record *api.Record) error {
+			got = append(got, record.Offset)
+			if record.Offset == 13 {
+				cancel()
+			}
+			return nil
+		})
+		cancel()
+		require.NoError(t, err, scenario)
+		require.Equal(t, tc.want, got, scenario)
+	}
+	srv.Stop()
+}
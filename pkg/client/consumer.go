@@ -0,0 +1,171 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	api "github.com/srikantrao/proglog/api/v1"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type ConsumerConfig struct {
+	// Offsets stores the consumer's position. Defaults to an in-memory
+	// store, so the consumer only resumes within the life of the process.
+	Offsets OffsetStore
+	// StartOffset is where to start when nothing has been committed yet,
+	// unless StartPosition says otherwise.
+	StartOffset   uint64
+	StartPosition api.StartPosition
+	// StartTimestamp is the time to start at for START_POSITION_TIMESTAMP,
+	// and FromEnd how many records before the end of the log to start at for
+	// START_POSITION_FROM_END.
+	StartTimestamp time.Time
+	FromEnd        uint64
+	// OutOfRangePolicy is what the server does when the consumer's position
+	// is no longer in the log, e.g. after it has been truncated.
+	OutOfRangePolicy api.OutOfRangePolicy
+	// Filter, if set, has the server send only the matching records.
+	Filter *api.Filter
+	// MaxBatchRecords, MaxBatchBytes, MinBytes and MaxWait have the server
+	// send records in batches, see api.ConsumeRequest. Records are still
+	// handled and committed one at a time.
+	MaxBatchRecords uint64
+	MaxBatchBytes   uint64
+	MinBytes        uint64
+	MaxWait         time.Duration
+	// ReconnectBackoff is the delay before reopening a failed stream,
+	// doubled on every consecutive failure up to MaxReconnectBackoff.
+	ReconnectBackoff    time.Duration
+	MaxReconnectBackoff time.Duration
+}
+
+// Consumer reads records with ConsumeStream, committing its position after
+// every handled record and reopening the stream when it fails.
+type Consumer struct {
+	client api.LogClient
+	config ConsumerConfig
+}
+
+func NewConsumer(client api.LogClient, c ConsumerConfig) *Consumer {
+	if c.Offsets == nil {
+		c.Offsets = &MemoryOffsetStore{}
+	}
+	if c.ReconnectBackoff == 0 {
+		c.ReconnectBackoff = 100 * time.Millisecond
+	}
+	if c.MaxReconnectBackoff == 0 {
+		c.MaxReconnectBackoff = 10 * time.Second
+	}
+	return &Consumer{
+		client: client,
+		config: c,
+	}
+}
+
+// Consume calls handle for every record from the committed offset onwards
+// until ctx is done, handle returns an error or the server returns an error
+// that isn't worth retrying. A record whose handler fails is not committed,
+// so it is delivered again by the next Consume. Errors from the server are
+// returned as the typed errors of the api package, see api.DecodeError.
+func (c *Consumer) Consume(ctx context.Context, handle func(*api.Record) error) error {
+	next, ok, err := c.config.Offsets.Load()
+	if err != nil {
+		return err
+	}
+	// Once the consumer has a position, it resumes from it.
+	start := api.StartPosition_START_POSITION_OFFSET
+	if !ok {
+		next = c.config.StartOffset
+		start = c.config.StartPosition
+	}
+	var maxWait *durationpb.Duration
+	if c.config.MaxWait != 0 {
+		maxWait = durationpb.New(c.config.MaxWait)
+	}
+	backoff := c.config.ReconnectBackoff
+	for {
+		var stream api.Log_ConsumeStreamClient
+		var err error
+		if start != api.StartPosition_START_POSITION_OFFSET {
+			// Resolve the start position once, so that a reopened stream
+			// doesn't resolve e.g. LATEST again and skip the records
+			// appended in between.
+			if next, err = c.resolve(ctx, start, next); err == nil {
+				start = api.StartPosition_START_POSITION_OFFSET
+			}
+		}
+		if err == nil {
+			stream, err = c.client.ConsumeStream(ctx, &api.ConsumeRequest{
+				Offset:           next,
+				Filter:           c.config.Filter,
+				OutOfRangePolicy: c.config.OutOfRangePolicy,
+				MaxBatchRecords:  c.config.MaxBatchRecords,
+				MaxBatchBytes:    c.config.MaxBatchBytes,
+				MinBytes:         c.config.MinBytes,
+				MaxWait:          maxWait,
+			})
+		}
+		for err == nil {
+			var res *api.ConsumeResponse
+			if res, err = stream.Recv(); err != nil {
+				break
+			}
+			records := res.Records
+			if res.Record != nil {
+				records = []*api.Record{res.Record}
+			}
+			for _, record := range records {
+				if ctx.Err() != nil {
+					// Don't hand out records buffered before cancellation.
+					return nil
+				}
+				if err := handle(record); err != nil {
+					return err
+				}
+				next = record.Offset + 1
+				if err := c.config.Offsets.Commit(next); err != nil {
+					return err
+				}
+			}
+			backoff = c.config.ReconnectBackoff
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !retryable(err) {
+			return api.DecodeError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > c.config.MaxReconnectBackoff {
+			backoff = c.config.MaxReconnectBackoff
+		}
+	}
+}
+
+// resolve returns the offset of the first record the server would send from
+// start now, or where the next record will be if there's none yet.
+func (c *Consumer) resolve(ctx context.Context, start api.StartPosition, offset uint64) (uint64, error) {
+	req := &api.ConsumeRequest{
+		Offset:           offset,
+		StartPosition:    start,
+		FromEnd:          c.config.FromEnd,
+		OutOfRangePolicy: c.config.OutOfRangePolicy,
+	}
+	if !c.config.StartTimestamp.IsZero() {
+		req.StartTimestamp = timestamppb.New(c.config.StartTimestamp)
+	}
+	res, err := c.client.Consume(ctx, req)
+	if err == nil {
+		return res.Record.Offset, nil
+	}
+	// There's no record at the position yet: the consumer waits there.
+	if e, ok := api.DecodeError(err).(api.ErrOffsetOutOfRange); ok && e.Offset >= e.LowWatermark {
+		return e.Offset, nil
+	}
+	return 0, err
+}
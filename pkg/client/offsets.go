@@ -0,0 +1,67 @@
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// OffsetStore persists the next offset a Consumer should read.
+type OffsetStore interface {
+	// Load returns the committed offset and false if nothing was committed.
+	Load() (uint64, bool, error)
+	Commit(offset uint64) error
+}
+
+type MemoryOffsetStore struct {
+	mu        sync.Mutex
+	offset    uint64
+	committed bool
+}
+
+func (s *MemoryOffsetStore) Load() (uint64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.offset, s.committed, nil
+}
+
+func (s *MemoryOffsetStore) Commit(offset uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset = offset
+	s.committed = true
+	return nil
+}
+
+// FileOffsetStore keeps the committed offset in a file so consumers resume
+// where they left off after a restart.
+type FileOffsetStore struct {
+	Path string
+}
+
+func (s *FileOffsetStore) Load() (uint64, bool, error) {
+	b, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	off, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return off, true, nil
+}
+
+// Commit writes the offset to a temporary file and renames it over the old
+// one so that a crash never leaves a partially written offset behind.
+func (s *FileOffsetStore) Commit(offset uint64) error {
+	tmp := s.Path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strconv.FormatUint(offset, 10)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.Path)
+}
@@ -0,0 +1,248 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	api "github.com/srikantrao/proglog/api/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var ErrProducerClosed = errors.New("producer closed")
+
+type ProducerConfig struct {
+	// BatchSize is the maximum number of records sent together.
+	BatchSize int
+	// Linger is how long a batch waits for more records before it is sent.
+	Linger time.Duration
+	// MaxInFlight is the number of batches that may be waiting for
+	// acknowledgement at once. Use 1 to keep records in the order they were
+	// produced. With more, batches may reach the server out of order and are
+	// rejected with a retryable error until the batches before them,
+	// starting with the producer's first, have been appended, so allow
+	// enough Retries. The server forgets a producer that has been idle for
+	// longer than its log's ProducerExpiry, after which the producer's
+	// records are rejected: create a new Producer after a long pause.
+	MaxInFlight int
+	// Retries is how many times a batch is resent after a retryable error.
+	Retries int
+	// RetryBackoff is the delay before the first retry, doubled after
+	// every attempt.
+	RetryBackoff time.Duration
+}
+
+type ProduceResult struct {
+	Offset uint64
+	Err    error
+}
+
+type pending struct {
+	record   *api.Record
+	sequence uint64
+	result   chan ProduceResult
+}
+
+// Producer batches records and writes them to the log asynchronously. Each
+// batch is sent over its own ProduceStream. Records carry the producer's ID
+// and a sequence number so that the log discards the duplicates retries
+// would otherwise create.
+type Producer struct {
+	client   api.LogClient
+	config   ProducerConfig
+	id       uint64
+	sequence uint64
+	mu       sync.Mutex
+	batch    []*pending
+	timer    *time.Timer
+	gen      uint64
+	closed   bool
+	batches  chan []*pending
+	wg       sync.WaitGroup
+	done     chan struct{}
+}
+
+func NewProducer(client api.LogClient, c ProducerConfig) *Producer {
+	if c.BatchSize == 0 {
+		c.BatchSize = 100
+	}
+	if c.Linger == 0 {
+		c.Linger = 5 * time.Millisecond
+	}
+	if c.MaxInFlight == 0 {
+		c.MaxInFlight = 1
+	}
+	if c.RetryBackoff == 0 {
+		c.RetryBackoff = 100 * time.Millisecond
+	}
+	p := &Producer{
+		client:  client,
+		config:  c,
+		id:      newProducerID(),
+		batches: make(chan []*pending, c.MaxInFlight),
+		done:    make(chan struct{}),
+	}
+	go p.dispatch()
+	return p
+}
+
+// Produce queues the record to be written and returns a channel that receives
+// the record's offset once its batch has been acknowledged. Records without a
+// timestamp are stamped with the current time. The producer sends a copy of
+// the record, so the caller keeps ownership of it.
+func (p *Producer) Produce(record *api.Record) <-chan ProduceResult {
+	record = proto.Clone(record).(*api.Record)
+	if record.Timestamp == nil {
+		record.Timestamp = timestamppb.Now()
+	}
+	pr := &pending{
+		record: record,
+		result: make(chan ProduceResult, 1),
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		pr.result <- ProduceResult{Err: ErrProducerClosed}
+		return pr.result
+	}
+	p.sequence++
+	pr.sequence = p.sequence
+	p.batch = append(p.batch, pr)
+	if len(p.batch) >= p.config.BatchSize {
+		p.flushLocked()
+	} else if len(p.batch) == 1 {
+		gen := p.gen
+		p.timer = time.AfterFunc(p.config.Linger, func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if p.gen == gen {
+				p.flushLocked()
+			}
+		})
+	}
+	return pr.result
+}
+
+// Flush sends any partially filled batch and waits until every record
+// produced before the call has been acknowledged or failed.
+func (p *Producer) Flush() {
+	p.mu.Lock()
+	p.flushLocked()
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// Close flushes the producer and stops it. Records produced after Close fail
+// with ErrProducerClosed.
+func (p *Producer) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.flushLocked()
+	p.closed = true
+	close(p.batches)
+	p.mu.Unlock()
+	<-p.done
+	p.wg.Wait()
+	return nil
+}
+
+func (p *Producer) flushLocked() {
+	p.gen++
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	if len(p.batch) == 0 {
+		return
+	}
+	p.wg.Add(1)
+	p.batches <- p.batch
+	p.batch = nil
+}
+
+// dispatch sends batches in order, never more than MaxInFlight at a time.
+func (p *Producer) dispatch() {
+	defer close(p.done)
+	inFlight := make(chan struct{}, p.config.MaxInFlight)
+	for batch := range p.batches {
+		inFlight <- struct{}{}
+		go func(batch []*pending) {
+			defer func() { <-inFlight }()
+			defer p.wg.Done()
+			p.send(batch)
+		}(batch)
+	}
+}
+
+func (p *Producer) send(batch []*pending) {
+	backoff := p.config.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		acked, err := p.sendOnce(batch)
+		batch = batch[acked:]
+		if err == nil {
+			return
+		}
+		if attempt >= p.config.Retries || !retryable(err) {
+			err = api.DecodeError(err)
+			for _, pr := range batch {
+				pr.result <- ProduceResult{Err: err}
+			}
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// sendOnce writes the batch over a new stream and returns how many records
+// were acknowledged before any error.
+func (p *Producer) sendOnce(batch []*pending) (int, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := p.client.ProduceStream(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, pr := range batch {
+		err := stream.Send(&api.ProduceRequest{
+			Record:     pr.record,
+			ProducerId: p.id,
+			Sequence:   pr.sequence,
+		})
+		if err != nil {
+			// The real error is returned by Recv.
+			break
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		return 0, err
+	}
+	for i, pr := range batch {
+		res, err := stream.Recv()
+		if err != nil {
+			return i, err
+		}
+		pr.result <- ProduceResult{Offset: res.Offset}
+	}
+	return len(batch), nil
+}
+
+// newProducerID returns a random, non-zero producer ID.
+func newProducerID() uint64 {
+	var b [8]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			panic(err)
+		}
+		if id := binary.BigEndian.Uint64(b[:]); id != 0 {
+			return id
+		}
+	}
+}
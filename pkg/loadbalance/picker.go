@@ -0,0 +1,73 @@
+package loadbalance
+
+import (
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+var _ base.PickerBuilder = (*pickerBuilder)(nil)
+
+type pickerBuilder struct{}
+
+func (pickerBuilder) Build(buildInfo base.PickerBuildInfo) balancer.Picker {
+	p := &Picker{}
+	for sc, scInfo := range buildInfo.ReadySCs {
+		isLeader, _ := scInfo.Address.Attributes.Value(isLeaderAttribute).(bool)
+		if isLeader {
+			p.leader = sc
+			continue
+		}
+		p.followers = append(p.followers, sc)
+	}
+	return p
+}
+
+var _ balancer.Picker = (*Picker)(nil)
+
+// readOnlyMethods are the RPCs any server can serve. Everything else, such
+// as produce, transaction, schema and admin writes, goes to the leader.
+var readOnlyMethods = map[string]bool{
+	"/log.v1.Log/Consume":        true,
+	"/log.v1.Log/ConsumeStream":  true,
+	"/log.v1.Log/ConsumeFlow":    true,
+	"/log.v1.Log/ConsumeRange":   true,
+	"/log.v1.Log/GetServers":     true,
+	"/log.v1.Log/GetSchema":      true,
+	"/log.v1.Admin/GetOffsets":   true,
+	"/log.v1.Admin/GetSegments":  true,
+	"/log.v1.Admin/GetDiskUsage": true,
+}
+
+// Picker routes writes to the leader and round-robins reads over the
+// followers, falling back to the leader when there are none.
+type Picker struct {
+	leader    balancer.SubConn
+	followers []balancer.SubConn
+	current   uint64
+}
+
+func (p *Picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	var result balancer.PickResult
+	if !readOnlyMethods[info.FullMethodName] || len(p.followers) == 0 {
+		result.SubConn = p.leader
+	} else {
+		result.SubConn = p.nextFollower()
+	}
+	if result.SubConn == nil {
+		return result, balancer.ErrNoSubConnAvailable
+	}
+	return result, nil
+}
+
+func (p *Picker) nextFollower() balancer.SubConn {
+	cur := atomic.AddUint64(&p.current, uint64(1))
+	return p.followers[cur%uint64(len(p.followers))]
+}
+
+func init() {
+	balancer.Register(
+		base.NewBalancerBuilder(Name, pickerBuilder{}, base.Config{}),
+	)
+}
@@ -0,0 +1,93 @@
+package loadbalance
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+func TestPickerNoSubConnAvailable(t *testing.T) {
+	picker := pickerBuilder{}.Build(base.PickerBuildInfo{})
+	for _, method := range []string{
+		"/log.v1.Log/Produce",
+		"/log.v1.Log/Consume",
+	} {
+		info := balancer.PickInfo{FullMethodName: method}
+		result, err := picker.Pick(info)
+		require.Equal(t, balancer.ErrNoSubConnAvailable, err)
+		require.Nil(t, result.SubConn)
+	}
+}
+
+func TestPickerProducesToLeader(t *testing.T) {
+	picker, subConns := setupPickerTest()
+	info := balancer.PickInfo{FullMethodName: "/log.v1.Log/Produce"}
+	for i := 0; i < 5; i++ {
+		gotPick, err := picker.Pick(info)
+		require.NoError(t, err)
+		require.Equal(t, subConns[0], gotPick.SubConn)
+	}
+}
+
+func TestPickerWritesToLeader(t *testing.T) {
+	picker, subConns := setupPickerTest()
+	for _, method := range []string{
+		"/log.v1.Log/ProduceStream",
+		"/log.v1.Log/BeginTransaction",
+		"/log.v1.Log/CommitTransaction",
+		"/log.v1.Log/AbortTransaction",
+		"/log.v1.Log/RegisterSchema",
+		"/log.v1.Admin/Truncate",
+		"/log.v1.Admin/RollSegment",
+	} {
+		gotPick, err := picker.Pick(balancer.PickInfo{FullMethodName: method})
+		require.NoError(t, err)
+		require.Equal(t, subConns[0], gotPick.SubConn, method)
+	}
+}
+
+func TestPickerConsumesFromFollowers(t *testing.T) {
+	picker, subConns := setupPickerTest()
+	info := balancer.PickInfo{FullMethodName: "/log.v1.Log/Consume"}
+	var prev balancer.SubConn
+	for i := 0; i < 5; i++ {
+		gotPick, err := picker.Pick(info)
+		require.NoError(t, err)
+		require.True(t, gotPick.SubConn != subConns[0])
+		require.True(t, gotPick.SubConn != prev)
+		prev = gotPick.SubConn
+	}
+}
+
+func setupPickerTest() (balancer.Picker, []*subConn) {
+	var subConns []*subConn
+	buildInfo := base.PickerBuildInfo{
+		ReadySCs: make(map[balancer.SubConn]base.SubConnInfo),
+	}
+	for i := 0; i < 3; i++ {
+		sc := &subConn{}
+		addr := resolver.Address{
+			Attributes: attributes.New(isLeaderAttribute, i == 0),
+		}
+		// The 0th sub conn is the leader.
+		sc.UpdateAddresses([]resolver.Address{addr})
+		buildInfo.ReadySCs[sc] = base.SubConnInfo{Address: addr}
+		subConns = append(subConns, sc)
+	}
+	return pickerBuilder{}.Build(buildInfo), subConns
+}
+
+// subConn implements balancer.SubConn.
+type subConn struct {
+	addrs []resolver.Address
+}
+
+func (s *subConn) UpdateAddresses(addrs []resolver.Address) {
+	s.addrs = addrs
+}
+
+func (s *subConn) Connect() {}
@@ -0,0 +1,165 @@
+// Package loadbalance registers a "proglog" resolver and balancer with gRPC.
+// Clients dial "proglog:///<addr>" with any server in the cluster; the
+// resolver discovers the rest through the GetServers RPC and the balancer
+// sends writes to the leader and spreads reads over the followers.
+package loadbalance
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	api "github.com/srikantrao/proglog/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+const Name = "proglog"
+
+const isLeaderAttribute = "is_leader"
+
+var (
+	// RefreshInterval is how often the resolver asks the cluster for its
+	// servers in addition to when gRPC asks it to resolve.
+	RefreshInterval = 10 * time.Second
+	// resolveTimeout bounds each GetServers call.
+	resolveTimeout = 5 * time.Second
+)
+
+type Resolver struct {
+	mu            sync.Mutex
+	clientConn    resolver.ClientConn
+	dialOpts      []grpc.DialOption
+	serviceConfig *serviceconfig.ParseResult
+	target        string
+	servers       []*api.Server
+	// resolve asks the background goroutine to resolve right away.
+	resolve   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ resolver.Builder = (*Resolver)(nil)
+
+func (r *Resolver) Build(
+	target resolver.Target,
+	cc resolver.ClientConn,
+	opts resolver.BuildOptions,
+) (resolver.Resolver, error) {
+	res := &Resolver{
+		clientConn: cc,
+		target:     target.Endpoint,
+		resolve:    make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	if opts.DialCreds != nil {
+		res.dialOpts = append(res.dialOpts, grpc.WithTransportCredentials(opts.DialCreds))
+	} else {
+		res.dialOpts = append(res.dialOpts, grpc.WithInsecure())
+	}
+	res.serviceConfig = cc.ParseServiceConfig(
+		fmt.Sprintf(`{"loadBalancingConfig":[{"%s":{}}]}`, Name),
+	)
+	res.ResolveNow(resolver.ResolveNowOptions{})
+	go res.run()
+	return res, nil
+}
+
+func (r *Resolver) Scheme() string {
+	return Name
+}
+
+func init() {
+	resolver.Register(&Resolver{})
+}
+
+var _ resolver.Resolver = (*Resolver)(nil)
+
+// ResolveNow asks the background goroutine to resolve. gRPC calls it on
+// its own goroutines, which mustn't block on the network.
+func (r *Resolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.resolve <- struct{}{}:
+	default:
+		// A resolution is already pending.
+	}
+}
+
+// resolveServers asks the target for the cluster's servers. If the target is
+// unreachable the servers learned from earlier calls are tried in turn, so
+// the client keeps working after the node it was given goes away.
+func (r *Resolver) resolveServers() {
+	r.mu.Lock()
+	candidates := []string{r.target}
+	for _, server := range r.servers {
+		if server.RpcAddr != r.target {
+			candidates = append(candidates, server.RpcAddr)
+		}
+	}
+	r.mu.Unlock()
+	var servers []*api.Server
+	var err error
+	for _, addr := range candidates {
+		if servers, err = r.getServers(addr); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		r.clientConn.ReportError(err)
+		return
+	}
+	r.mu.Lock()
+	r.servers = servers
+	r.mu.Unlock()
+	var addrs []resolver.Address
+	for _, server := range servers {
+		addrs = append(addrs, resolver.Address{
+			Addr:       server.RpcAddr,
+			Attributes: attributes.New(isLeaderAttribute, server.IsLeader),
+		})
+	}
+	r.clientConn.UpdateState(resolver.State{
+		Addresses:     addrs,
+		ServiceConfig: r.serviceConfig,
+	})
+}
+
+func (r *Resolver) getServers(addr string) ([]*api.Server, error) {
+	conn, err := grpc.Dial(addr, r.dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	res, err := api.NewLogClient(conn).GetServers(ctx, &api.GetServersRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return res.Servers, nil
+}
+
+// run resolves when asked to and every RefreshInterval until the resolver
+// is closed.
+func (r *Resolver) run() {
+	ticker := time.NewTicker(RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-r.resolve:
+		case <-ticker.C:
+		}
+		r.resolveServers()
+	}
+}
+
+func (r *Resolver) Close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+	})
+}
@@ -0,0 +1,137 @@
+package loadbalance
+
+import (
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+
+	api "github.com/srikantrao/proglog/api/v1"
+	"github.com/srikantrao/proglog/internal/config"
+	"github.com/srikantrao/proglog/internal/server"
+)
+
+func TestResolver(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      config.ServerCertFile,
+		KeyFile:       config.ServerKeyFile,
+		CAFile:        config.CAFile,
+		Server:        true,
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+	serverCreds := credentials.NewTLS(tlsConfig)
+
+	srv, err := server.NewGRPCServer(&server.Config{
+		GetServerer: &getServers{},
+	}, grpc.Creds(serverCreds))
+	require.NoError(t, err)
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	conn := &clientConn{}
+	tlsConfig, err = config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      config.RootClientCertFile,
+		KeyFile:       config.RootClientKeyFile,
+		CAFile:        config.CAFile,
+		Server:        false,
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+	clientCreds := credentials.NewTLS(tlsConfig)
+	opts := resolver.BuildOptions{
+		DialCreds: clientCreds,
+	}
+	r := &Resolver{}
+	res, err := r.Build(
+		resolver.Target{
+			Endpoint: l.Addr().String(),
+		},
+		conn,
+		opts,
+	)
+	require.NoError(t, err)
+	defer res.Close()
+
+	wantState := resolver.State{
+		Addresses: []resolver.Address{{
+			Addr:       "localhost:9001",
+			Attributes: attributes.New(isLeaderAttribute, true),
+		}, {
+			Addr:       "localhost:9002",
+			Attributes: attributes.New(isLeaderAttribute, false),
+		}},
+	}
+	resolved := func() bool {
+		return reflect.DeepEqual(wantState, conn.State())
+	}
+	require.Eventually(t, resolved, time.Second, 10*time.Millisecond)
+
+	// Once the seed address is gone the resolver asks the servers it
+	// already knows about.
+	conn.UpdateState(resolver.State{})
+	r = res.(*Resolver)
+	r.mu.Lock()
+	r.target = "127.0.0.1:1"
+	r.servers = []*api.Server{{RpcAddr: l.Addr().String()}}
+	r.mu.Unlock()
+	res.ResolveNow(resolver.ResolveNowOptions{})
+	require.Eventually(t, resolved, time.Second, 10*time.Millisecond)
+}
+
+type getServers struct{}
+
+func (s *getServers) GetServers() ([]*api.Server, error) {
+	return []*api.Server{{
+		Id:       "leader",
+		RpcAddr:  "localhost:9001",
+		IsLeader: true,
+	}, {
+		Id:      "follower",
+		RpcAddr: "localhost:9002",
+	}}, nil
+}
+
+type clientConn struct {
+	resolver.ClientConn
+	mu    sync.Mutex
+	state resolver.State
+}
+
+func (c *clientConn) UpdateState(state resolver.State) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// The parsed service config is opaque, compare everything else.
+	state.ServiceConfig = nil
+	c.state = state
+	return nil
+}
+
+func (c *clientConn) State() resolver.State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+func (c *clientConn) ReportError(err error) {}
+
+func (c *clientConn) NewAddress(addrs []resolver.Address) {}
+
+func (c *clientConn) NewServiceConfig(config string) {}
+
+func (c *clientConn) ParseServiceConfig(
+	config string,
+) *serviceconfig.ParseResult {
+	return nil
+}